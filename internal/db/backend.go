@@ -0,0 +1,77 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// The database backends DB_BACKEND can select
+const (
+	BackendCouchbase = "couchbase"
+	BackendMemory    = "memory"
+	BackendBolt      = "bolt"
+)
+
+// ErrUnknownBackend is returned when DB_BACKEND names no known backend
+var ErrUnknownBackend = errors.New("unknown database backend")
+
+// Backend is a database the server can run against and set up. Close it once
+// it is no longer used, so a local database releases its file and flushes
+// its last writes.
+type Backend interface {
+	DBManagerInterface
+	SetupDB(bucketName, scopeName, collectionName, documentID string) error
+	Close() error
+}
+
+// GetBackendName gets the database backend to use, Couchbase by default
+func GetBackendName() (string, error) {
+	if err := loadOptionalConfig(); err != nil {
+		return "", err
+	}
+
+	if name := os.Getenv("DB_BACKEND"); name != "" {
+		return name, nil
+	}
+
+	return BackendCouchbase, nil
+}
+
+// OpenBackend connects to the database selected by DB_BACKEND
+func OpenBackend() (Backend, error) {
+	name, err := GetBackendName()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case BackendCouchbase:
+		return NewDBManager()
+	case BackendMemory:
+		return NewMemoryDBManager(), nil
+	case BackendBolt:
+		return NewBoltDBManager(GetDBPath())
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, name)
+	}
+}
+
+// InitBackend connects to the database selected by DB_BACKEND and sets it
+// up. Like InitDB, a failed setup is logged and the server starts anyway.
+func InitBackend() (Backend, error) {
+	backend, err := OpenBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	err = SetupBucket(backend)
+	if err != nil {
+		log.Printf("Failed to set up database: %v", err)
+		return backend, nil
+	}
+
+	log.Println("Database setup successfully")
+	return backend, nil
+}
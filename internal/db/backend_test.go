@@ -0,0 +1,484 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mxnyawi/gymSharkTask/internal/model"
+)
+
+// testBackends opens each backend for the conformance suite. Couchbase needs
+// a running cluster and config.env, so it only runs when COUCHBASE_TEST is set.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) Backend
+}{
+	{
+		name: BackendMemory,
+		open: func(t *testing.T) Backend {
+			backend := NewMemoryDBManager()
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		},
+	},
+	{
+		name: BackendBolt,
+		open: func(t *testing.T) Backend {
+			backend, err := NewBoltDBManager(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		},
+	},
+	{
+		name: BackendCouchbase,
+		open: func(t *testing.T) Backend {
+			if os.Getenv("COUCHBASE_TEST") == "" {
+				t.Skip("COUCHBASE_TEST is not set")
+			}
+
+			backend, err := NewDBManager()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		},
+	},
+}
+
+// conformanceTests check the behaviour every backend must share. Each test
+// gets its own collection holding an empty history document.
+var conformanceTests = []struct {
+	name string
+	test func(t *testing.T, backend Backend, bucket, scope, collection string)
+}{
+	{"Documents", testDocuments},
+	{"Namespaces", testNamespaces},
+	{"Replace with CAS", testReplaceDocument},
+	{"Orders", testOrders},
+	{"Demand over several pages", testReadDemand},
+	{"Batches", testBatches},
+	{"Stock", testStock},
+	{"Setup keeps history", testSetupKeepsHistory},
+	{"Migrate history", testMigrateHistory},
+	{"Migrate history appended to meanwhile", testMigrateHistoryRetry},
+}
+
+func TestBackendConformance(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for i, tt := range conformanceTests {
+				t.Run(tt.name, func(t *testing.T) {
+					dbManager := backend.open(t)
+					collection := fmt.Sprintf("conformance_%d_%d", time.Now().UnixNano(), i)
+
+					if err := dbManager.SetupDB("bucket", "scope", collection, "history"); err != nil {
+						t.Fatal(err)
+					}
+
+					tt.test(t, dbManager, "bucket", "scope", collection)
+				})
+			}
+		})
+	}
+}
+
+func testDocuments(t *testing.T, backend Backend, bucket, scope, collection string) {
+	if _, err := backend.GetUser(bucket, scope, collection, "test"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUser of a missing user returned %v, want ErrNotFound", err)
+	}
+	if _, err := backend.GetStock(bucket, scope, collection, "stock"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetStock of missing stock returned %v, want ErrNotFound", err)
+	}
+	if _, err := backend.GetCatalogue(bucket, scope, collection, CatalogueID("gloves")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetCatalogue of a missing catalogue returned %v, want ErrNotFound", err)
+	}
+	if _, _, err := backend.GetDocument(bucket, scope, collection, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetDocument of a missing document returned %v, want ErrNotFound", err)
+	}
+
+	user := User{Username: "test", Password: "hash"}
+	if err := backend.WriteDocument(bucket, scope, collection, user.Username, user); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := backend.GetUser(bucket, scope, collection, user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != user {
+		t.Errorf("GetUser returned %+v, want %+v", *got, user)
+	}
+
+	packages := model.Packages{Sizes: []int{5, 10}, Prices: []int{3, 5}}
+	if err := backend.WriteDocument(bucket, scope, collection, CatalogueID("gloves"), packages); err != nil {
+		t.Fatal(err)
+	}
+
+	catalogue, err := backend.GetCatalogue(bucket, scope, collection, CatalogueID("gloves"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*catalogue, packages) {
+		t.Errorf("GetCatalogue returned %+v, want %+v", *catalogue, packages)
+	}
+}
+
+func testNamespaces(t *testing.T, backend Backend, bucket, scope, collection string) {
+	other := collection + "_other"
+	if err := backend.CreateCollection(bucket, scope, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.WriteDocument(bucket, scope, collection, "test", User{Username: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.GetUser(bucket, scope, other, "test"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUser in another collection returned %v, want ErrNotFound", err)
+	}
+
+	if err := backend.WriteDocument(bucket, scope, other, "test", User{Username: "second"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{collection: "first", other: "second"} {
+		user, err := backend.GetUser(bucket, scope, name, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != want {
+			t.Errorf("GetUser in %s returned %q, want %q", name, user.Username, want)
+		}
+	}
+}
+
+func testReplaceDocument(t *testing.T, backend Backend, bucket, scope, collection string) {
+	history, cas, err := backend.GetDocument(bucket, scope, collection, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history.Migrated = 1
+	if err := backend.ReplaceDocument(bucket, scope, collection, "history", history, cas); err != nil {
+		t.Fatal(err)
+	}
+
+	history.Migrated = 2
+	if err := backend.ReplaceDocument(bucket, scope, collection, "history", history, cas); !errors.Is(err, ErrCasMismatch) {
+		t.Errorf("ReplaceDocument with a stale CAS returned %v, want ErrCasMismatch", err)
+	}
+
+	if err := backend.ReplaceDocument(bucket, scope, collection, "missing", history, cas); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReplaceDocument of a missing document returned %v, want ErrNotFound", err)
+	}
+
+	history, _, err = backend.GetDocument(bucket, scope, collection, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Migrated != 1 {
+		t.Errorf("history has %d migrated entries, want 1", history.Migrated)
+	}
+}
+
+func testOrders(t *testing.T, backend Backend, bucket, scope, collection string) {
+	var orders []OrderDocument
+	for i, amount := range []int{1, 251, 501, 12001, 750} {
+		document := Document{Order: model.Order{Amount: amount}}
+		orders = append(orders, OrderDocument{ID: NewOrderID(time.Unix(0, int64(i+1))), Document: document})
+	}
+
+	if err := backend.InsertOrders(bucket, scope, collection, orders[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.InsertOrders(bucket, scope, collection, orders[3:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.InsertOrders(bucket, scope, collection, orders[:1]); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("InsertOrders of a stored order returned %v, want ErrDocumentExists", err)
+	}
+
+	var amounts []int
+	after := ""
+	for {
+		page, err := backend.ListOrders(bucket, scope, collection, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, order := range page {
+			amounts = append(amounts, order.Order.Amount)
+		}
+		if len(page) < 2 {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+
+	if !reflect.DeepEqual(amounts, []int{1, 251, 501, 12001, 750}) {
+		t.Errorf("listed orders of amounts %v, want [1 251 501 12001 750]", amounts)
+	}
+
+	scanned := 0
+	err := ScanHistory(backend, bucket, scope, collection, func(Document) error {
+		scanned++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != len(orders) {
+		t.Errorf("ScanHistory returned %d orders, want %d", scanned, len(orders))
+	}
+}
+
+func testReadDemand(t *testing.T, backend Backend, bucket, scope, collection string) {
+	var orders []OrderDocument
+	var want []int
+	for i := 0; i <= historyPageSize; i++ {
+		document := Document{Packages: model.Packages{Sizes: []int{250, 500 + i}}, Order: model.Order{Amount: i + 1}}
+		orders = append(orders, OrderDocument{ID: NewOrderID(time.Unix(0, int64(i+1))), Document: document})
+		want = append(want, i+1)
+	}
+
+	// The latest order is multi-line, so it counts for neither
+	multiLine := Document{Packages: model.Packages{Sizes: []int{1}}, MultiLine: &model.MultiLineOrder{}}
+	orders = append(orders, OrderDocument{ID: NewOrderID(time.Unix(0, int64(len(orders)+1))), Document: multiLine})
+
+	if err := backend.InsertOrders(bucket, scope, collection, orders); err != nil {
+		t.Fatal(err)
+	}
+
+	demand, err := ReadDemand(backend, bucket, scope, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(demand.Amounts, want) {
+		t.Errorf("ReadDemand returned %d amounts, want %d from 1 up", len(demand.Amounts), len(want))
+	}
+	if !reflect.DeepEqual(demand.LatestSizes, []int{250, 500 + historyPageSize}) {
+		t.Errorf("ReadDemand returned latest sizes %v, want [250 %d]", demand.LatestSizes, 500+historyPageSize)
+	}
+}
+
+func testBatches(t *testing.T, backend Backend, bucket, scope, collection string) {
+	var orders []OrderDocument
+	for i, amount := range []int{1, 251, 501} {
+		document := Document{Order: model.Order{Amount: amount}}
+		orders = append(orders, OrderDocument{ID: NewOrderID(time.Unix(0, int64(i+1))), Batch: "import", Document: document})
+	}
+
+	unkeyed := orders[1]
+	unkeyed.Batch = ""
+	if err := backend.InsertOrders(bucket, scope, collection, []OrderDocument{unkeyed}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.InsertOrders(bucket, scope, collection, orders); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("InsertOrders of a batch with a stored order returned %v, want ErrDocumentExists", err)
+	}
+
+	stored, err := backend.ListOrders(bucket, scope, collection, "", len(orders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("a failed batch left %d orders stored, want 1", len(stored))
+	}
+
+	retry := []OrderDocument{orders[0], orders[2]}
+	if err := backend.InsertOrders(bucket, scope, collection, retry); err != nil {
+		t.Fatal(err)
+	}
+
+	retry[0].ID = NewOrderID(time.Unix(0, 4))
+	retry[1].ID = NewOrderID(time.Unix(0, 5))
+	if err := backend.InsertOrders(bucket, scope, collection, retry); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("InsertOrders of a stored batch returned %v, want ErrDocumentExists", err)
+	}
+
+	stored, err = backend.ListOrders(bucket, scope, collection, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(orders) {
+		t.Errorf("retrying a batch left %d orders stored, want %d", len(stored), len(orders))
+	}
+}
+
+func testStock(t *testing.T, backend Backend, bucket, scope, collection string) {
+	if err := backend.DecrementStock(bucket, scope, collection, "stock", map[int]int{250: 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DecrementStock of missing stock returned %v, want ErrNotFound", err)
+	}
+
+	if err := backend.WriteDocument(bucket, scope, collection, "stock", Stock{Levels: map[int]int{250: 3, 500: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.DecrementStock(bucket, scope, collection, "stock", map[int]int{250: 2, 1000: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.DecrementStock(bucket, scope, collection, "stock", map[int]int{250: 1, 500: 2}); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("DecrementStock past the stock returned %v, want ErrInsufficientStock", err)
+	}
+
+	stock, err := backend.GetStock(bucket, scope, collection, "stock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int{250: 1, 500: 1}; !reflect.DeepEqual(stock.Levels, want) {
+		t.Errorf("stock levels are %v, want %v", stock.Levels, want)
+	}
+}
+
+func testSetupKeepsHistory(t *testing.T, backend Backend, bucket, scope, collection string) {
+	written := DocumentHistory{History: []Document{{Order: model.Order{Amount: 1}}}}
+	if err := backend.WriteDocument(bucket, scope, collection, "history", written); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.SetupDB(bucket, scope, collection, "history"); err != nil {
+		t.Fatal(err)
+	}
+
+	history, _, err := backend.GetDocument(bucket, scope, collection, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.History) != 1 {
+		t.Errorf("history has %d entries after a second setup, want 1", len(history.History))
+	}
+}
+
+func testMigrateHistory(t *testing.T, backend Backend, bucket, scope, collection string) {
+	written := DocumentHistory{History: []Document{{Order: model.Order{Amount: 1}}, {Order: model.Order{Amount: 251}}}}
+	if err := backend.WriteDocument(bucket, scope, collection, "history", written); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []int{2, 0} {
+		migrated, err := MigrateHistory(backend, bucket, scope, collection, "history")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated != want {
+			t.Errorf("MigrateHistory copied %d orders, want %d", migrated, want)
+		}
+	}
+
+	demand, err := ReadDemand(backend, bucket, scope, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(demand.Amounts, []int{1, 251}) {
+		t.Errorf("ReadDemand returned amounts %v, want [1 251]", demand.Amounts)
+	}
+}
+
+// lateAppender is a Backend that appends to the history, as a writer of the
+// old layout would, each time right after the history is first read
+type lateAppender struct {
+	Backend
+	late [][]Document
+}
+
+func (b *lateAppender) GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, Cas, error) {
+	history, cas, err := b.Backend.GetDocument(bucketName, scopeName, collectionName, documentID)
+	if err != nil || len(b.late) == 0 {
+		return history, cas, err
+	}
+
+	appended := *history
+	appended.History = append(append([]Document(nil), history.History...), b.late[0]...)
+	b.late = b.late[1:]
+	if err := b.Backend.ReplaceDocument(bucketName, scopeName, collectionName, documentID, appended, cas); err != nil {
+		return nil, 0, err
+	}
+
+	// The caller is left with the history and CAS from before the append
+	return history, cas, nil
+}
+
+func testMigrateHistoryRetry(t *testing.T, backend Backend, bucket, scope, collection string) {
+	history := DocumentHistory{History: []Document{{Order: model.Order{Amount: 1}}}}
+	if err := backend.WriteDocument(bucket, scope, collection, "history", history); err != nil {
+		t.Fatal(err)
+	}
+
+	appender := &lateAppender{Backend: backend, late: [][]Document{
+		{{Order: model.Order{Amount: 251}}},
+		{{Order: model.Order{Amount: 501}}, {Order: model.Order{Amount: 750}}},
+	}}
+
+	// The first two attempts lose the CAS race, so the third migrates every
+	// late entry
+	migrated, err := MigrateHistory(appender, bucket, scope, collection, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 4 || len(appender.late) != 0 {
+		t.Errorf("MigrateHistory copied %d orders with %d appends left, want 4 and none", migrated, len(appender.late))
+	}
+
+	stored, _, err := backend.GetDocument(bucket, scope, collection, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Migrated != 4 {
+		t.Errorf("history has %d migrated entries, want 4", stored.Migrated)
+	}
+
+	demand, err := ReadDemand(backend, bucket, scope, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(demand.Amounts, []int{1, 251, 501, 750}) {
+		t.Errorf("ReadDemand returned amounts %v, want [1 251 501 750]", demand.Amounts)
+	}
+}
+
+func TestBoltDBManagerReopen(t *testing.T) {
+	t.Setenv("DB_BACKEND", BackendBolt)
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+
+	// The file stays locked until the backend is closed
+	backend, err := OpenBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.SetupDB("bucket", "scope", "collection", "history"); err != nil {
+		t.Fatal(err)
+	}
+	order := NewOrderDocument(Document{Order: model.Order{Amount: 251}})
+	if err := backend.InsertOrders("bucket", "scope", "collection", []OrderDocument{order}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err = OpenBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	orders, err := backend.ListOrders("bucket", "scope", "collection", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != order.ID {
+		t.Errorf("listed %+v after reopening, want order %s", orders, order.ID)
+	}
+}
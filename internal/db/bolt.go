@@ -0,0 +1,401 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultDBPath is the file the embedded database is kept in when DB_PATH is
+// not set
+const DefaultDBPath = "packing.db"
+
+// The names of the bolt buckets are prefixed with what they hold, so that any
+// name, even an empty one, maps to a valid bolt bucket
+const (
+	boltBucketPrefix     = "bucket:"
+	boltScopePrefix      = "scope:"
+	boltCollectionPrefix = "collection:"
+)
+
+// boltAdmins is the bolt bucket holding the admin users
+var boltAdmins = []byte("admins")
+
+// BoltDBManager keeps every document in an embedded bolt database file. Each
+// bucket, scope and collection is a bolt bucket nested in the one above it,
+// and each document is stored as its CAS followed by its JSON.
+type BoltDBManager struct {
+	localConfig
+	DB *bolt.DB
+}
+
+// GetDBPath gets the path of the embedded database file
+func GetDBPath() string {
+	if path := os.Getenv("DB_PATH"); path != "" {
+		return path
+	}
+
+	return DefaultDBPath
+}
+
+// NewBoltDBManager opens the embedded database at path, creating it if needed
+func NewBoltDBManager(path string) (*BoltDBManager, error) {
+	database, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	return &BoltDBManager{DB: database}, nil
+}
+
+// Close closes the database file
+func (db *BoltDBManager) Close() error {
+	return db.DB.Close()
+}
+
+// CreateAdminUser records an admin user with a hash of the password
+func (db *BoltDBManager) CreateAdminUser(username, password string) error {
+	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
+	}
+
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		admins, err := tx.CreateBucketIfNotExists(boltAdmins)
+		if err != nil {
+			return err
+		}
+		return admins.Put([]byte(username), []byte(hash))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
+	}
+
+	return nil
+}
+
+// SetupDB creates the bucket, scope and collection and an empty order history
+func (db *BoltDBManager) SetupDB(bucketName, scopeName, collectionName, documentID string) error {
+	return setupLocalDB(db, bucketName, scopeName, collectionName, documentID)
+}
+
+// CreateBucket creates a bucket, unless it already exists
+func (db *BoltDBManager) CreateBucket(bucketName string) error {
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltName(boltBucketPrefix, bucketName))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	return nil
+}
+
+// CreateScope creates a scope in a bucket, unless it already exists
+func (db *BoltDBManager) CreateScope(bucketName, scopeName string) error {
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltName(boltBucketPrefix, bucketName))
+		if bucket == nil {
+			return fmt.Errorf("%w: bucket %s", ErrCollectionNotFound, bucketName)
+		}
+
+		_, err := bucket.CreateBucketIfNotExists(boltName(boltScopePrefix, scopeName))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create scope: %w", err)
+	}
+
+	return nil
+}
+
+// CreateCollection creates a collection in a scope, unless it already exists
+func (db *BoltDBManager) CreateCollection(bucketName, scopeName, collectionName string) error {
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		scope := tx.Bucket(boltName(boltBucketPrefix, bucketName))
+		if scope != nil {
+			scope = scope.Bucket(boltName(boltScopePrefix, scopeName))
+		}
+		if scope == nil {
+			return fmt.Errorf("%w: scope %s.%s", ErrCollectionNotFound, bucketName, scopeName)
+		}
+
+		_, err := scope.CreateBucketIfNotExists(boltName(boltCollectionPrefix, collectionName))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create collection: %w", err)
+	}
+
+	return nil
+}
+
+// GetDocument gets the document from the database with its CAS
+func (db *BoltDBManager) GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, Cas, error) {
+	var document DocumentHistory
+	cas, err := db.get(bucketName, scopeName, collectionName, documentID, &document)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get document: %w", err)
+	}
+
+	return &document, cas, nil
+}
+
+// GetUser gets the user from the database
+func (db *BoltDBManager) GetUser(bucketName, scopeName, collectionName, documentID string) (*User, error) {
+	var user User
+	if _, err := db.get(bucketName, scopeName, collectionName, documentID, &user); err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// GetStock gets the stock levels from the database
+func (db *BoltDBManager) GetStock(bucketName, scopeName, collectionName, documentID string) (*Stock, error) {
+	var stock Stock
+	if _, err := db.get(bucketName, scopeName, collectionName, documentID, &stock); err != nil {
+		return nil, fmt.Errorf("failed to get stock: %w", err)
+	}
+
+	return &stock, nil
+}
+
+// GetCatalogue gets a stored package catalogue from the database
+func (db *BoltDBManager) GetCatalogue(bucketName, scopeName, collectionName, documentID string) (*model.Packages, error) {
+	var packages model.Packages
+	if _, err := db.get(bucketName, scopeName, collectionName, documentID, &packages); err != nil {
+		return nil, fmt.Errorf("failed to get catalogue: %w", err)
+	}
+
+	return &packages, nil
+}
+
+// WriteDocument writes a document, replacing any document with the same ID
+func (db *BoltDBManager) WriteDocument(bucketName, scopeName, collectionName, documentID string, content interface{}) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+
+	err = db.update(bucketName, scopeName, collectionName, func(collection *bolt.Bucket) error {
+		return boltPut(collection, documentID, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceDocument replaces a document, but only if its CAS still matches the
+// one it was read with. Otherwise it fails with ErrCasMismatch.
+func (db *BoltDBManager) ReplaceDocument(bucketName, scopeName, collectionName, documentID string, content interface{}, cas Cas) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to replace document: %w", err)
+	}
+
+	err = db.update(bucketName, scopeName, collectionName, func(collection *bolt.Bucket) error {
+		stored, _, err := boltGet(collection, documentID)
+		if err != nil {
+			return err
+		}
+		if stored != cas {
+			return fmt.Errorf("%w: %s", ErrCasMismatch, documentID)
+		}
+
+		return boltPut(collection, documentID, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace document: %w", err)
+	}
+
+	return nil
+}
+
+// InsertOrders stores each order as its own document, with the
+// BatchDocument of a keyed batch, in one transaction. Nothing is stored if
+// any of their IDs is already taken.
+func (db *BoltDBManager) InsertOrders(bucketName, scopeName, collectionName string, orders []OrderDocument) error {
+	err := db.update(bucketName, scopeName, collectionName, func(collection *bolt.Bucket) error {
+		for _, write := range orderWrites(orders) {
+			if collection.Get([]byte(write.id)) != nil {
+				return fmt.Errorf("%w: %s", ErrDocumentExists, write.id)
+			}
+
+			data, err := json.Marshal(write.content)
+			if err != nil {
+				return err
+			}
+
+			if err := boltPut(collection, write.id, data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert orders: %w", err)
+	}
+
+	return nil
+}
+
+// ListOrders lists up to limit orders with IDs after the given one, in the
+// order they were placed. An empty after starts from the first order.
+func (db *BoltDBManager) ListOrders(bucketName, scopeName, collectionName, after string, limit int) ([]OrderDocument, error) {
+	orders := []OrderDocument{}
+	err := db.view(bucketName, scopeName, collectionName, func(collection *bolt.Bucket) error {
+		prefix := []byte(OrderIDPrefix)
+		start := prefix
+		if after > OrderIDPrefix {
+			start = []byte(after)
+		}
+
+		cursor := collection.Cursor()
+		for key, value := cursor.Seek(start); key != nil && bytes.HasPrefix(key, prefix) && len(orders) < limit; key, value = cursor.Next() {
+			if string(key) <= after {
+				continue
+			}
+
+			var order OrderDocument
+			if err := json.Unmarshal(value[casSize:], &order); err != nil {
+				return fmt.Errorf("failed to get order content: %w", err)
+			}
+			orders = append(orders, order)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+
+	return orders, nil
+}
+
+// DecrementStock removes the used packages from the stock levels, or returns
+// them for negative counts. Nothing is removed if any size has too few
+// packages left.
+func (db *BoltDBManager) DecrementStock(bucketName, scopeName, collectionName, documentID string, used map[int]int) error {
+	return db.update(bucketName, scopeName, collectionName, func(collection *bolt.Bucket) error {
+		_, content, err := boltGet(collection, documentID)
+		if err != nil {
+			return fmt.Errorf("failed to get stock: %w", err)
+		}
+
+		data, err := decrementStock(content, used)
+		if err != nil {
+			return err
+		}
+
+		return boltPut(collection, documentID, data)
+	})
+}
+
+// get decodes the stored document into content and returns its CAS
+func (db *BoltDBManager) get(bucketName, scopeName, collectionName, documentID string, content interface{}) (Cas, error) {
+	var cas Cas
+	err := db.view(bucketName, scopeName, collectionName, func(collection *bolt.Bucket) error {
+		stored, data, err := boltGet(collection, documentID)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, content); err != nil {
+			return fmt.Errorf("failed to get content of %s: %w", documentID, err)
+		}
+
+		cas = stored
+		return nil
+	})
+
+	return cas, err
+}
+
+// view runs fn on a collection in a read-only transaction
+func (db *BoltDBManager) view(bucketName, scopeName, collectionName string, fn func(*bolt.Bucket) error) error {
+	return db.DB.View(func(tx *bolt.Tx) error {
+		collection, err := boltCollection(tx, bucketName, scopeName, collectionName)
+		if err != nil {
+			return err
+		}
+		return fn(collection)
+	})
+}
+
+// update runs fn on a collection in a read-write transaction, which is
+// rolled back if fn fails
+func (db *BoltDBManager) update(bucketName, scopeName, collectionName string, fn func(*bolt.Bucket) error) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		collection, err := boltCollection(tx, bucketName, scopeName, collectionName)
+		if err != nil {
+			return err
+		}
+		return fn(collection)
+	})
+}
+
+// boltCollection returns the bolt bucket of a collection
+func boltCollection(tx *bolt.Tx, bucketName, scopeName, collectionName string) (*bolt.Bucket, error) {
+	collection := tx.Bucket(boltName(boltBucketPrefix, bucketName))
+	if collection != nil {
+		collection = collection.Bucket(boltName(boltScopePrefix, scopeName))
+	}
+	if collection != nil {
+		collection = collection.Bucket(boltName(boltCollectionPrefix, collectionName))
+	}
+	if collection == nil {
+		return nil, fmt.Errorf("%w: %s.%s.%s", ErrCollectionNotFound, bucketName, scopeName, collectionName)
+	}
+
+	return collection, nil
+}
+
+// boltName returns the name of the bolt bucket holding a bucket, scope or
+// collection
+func boltName(prefix, name string) []byte {
+	return []byte(prefix + name)
+}
+
+// casSize is the length of the CAS stored before every document
+const casSize = 8
+
+// boltGet returns the CAS and JSON of a stored document
+func boltGet(collection *bolt.Bucket, documentID string) (Cas, []byte, error) {
+	value := collection.Get([]byte(documentID))
+	if value == nil {
+		return 0, nil, fmt.Errorf("%w: %s", ErrNotFound, documentID)
+	}
+
+	return Cas(binary.BigEndian.Uint64(value[:casSize])), value[casSize:], nil
+}
+
+// boltPut stores a new version of a document with a new CAS
+func boltPut(collection *bolt.Bucket, documentID string, content []byte) error {
+	if documentID == "" {
+		return errors.New("document ID is required")
+	}
+
+	cas, err := collection.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	value := make([]byte, casSize+len(content))
+	binary.BigEndian.PutUint64(value, cas)
+	copy(value[casSize:], content)
+
+	return collection.Put([]byte(documentID), value)
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -12,31 +13,88 @@ import (
 )
 
 type DBManagerInterface interface {
-	GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, error)
+	GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, Cas, error)
 	GetUser(bucketName, scopeName, collectionName, documentID string) (*User, error)
 	WriteDocument(bucket, scope, collection, id string, data interface{}) error
+	ReplaceDocument(bucket, scope, collection, id string, data interface{}, cas Cas) error
 	GetDBCreds() (string, string, string, string, error)
 	CreateAdminUser(username, password string) error
 	CreateBucket(bucketName string) error
 	CreateScope(bucketName, scopeName string) error
 	CreateCollection(bucketName, scopeName, collectionName string) error
 	GetClusterCredentials() (string, string, error)
+	GetStock(bucketName, scopeName, collectionName, documentID string) (*Stock, error)
+	DecrementStock(bucketName, scopeName, collectionName, documentID string, used map[int]int) error
+	GetCatalogue(bucketName, scopeName, collectionName, documentID string) (*model.Packages, error)
+	InsertOrders(bucketName, scopeName, collectionName string, orders []OrderDocument) error
+	ListOrders(bucketName, scopeName, collectionName, after string, limit int) ([]OrderDocument, error)
 }
 
+var (
+	// ErrNotFound is returned when a document does not exist
+	ErrNotFound = errors.New("document not found")
+	// ErrInsufficientStock is returned when a stock level would drop below zero
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrCasMismatch is returned when a document changed since it was read
+	ErrCasMismatch = errors.New("document changed since it was read")
+	// ErrDocumentExists is returned when a document to insert already exists
+	ErrDocumentExists = errors.New("document already exists")
+	// ErrCollectionNotFound is returned when a bucket, scope or collection
+	// does not exist
+	ErrCollectionNotFound = errors.New("collection not found")
+)
+
+// Cas identifies the version of a document that was read, so that it is only
+// replaced if nobody else has changed it since
+type Cas uint64
+
+// StockDocumentID is the ID of the stock levels document when STOCK_ID is not set
+const StockDocumentID = "stock"
+
 // DBManager is a struct that contains the Couchbase cluster
 type DBManager struct {
 	Cluster *gocb.Cluster
 }
 
-// DocumentHistory is a struct that contains the history of documents
+// DocumentHistory is a struct that contains the history of documents. Orders
+// used to be appended to one DocumentHistory; they are now stored as
+// OrderDocuments, and ScanHistory reads them back in the same order.
 type DocumentHistory struct {
 	History []Document `json:"history"`
+	// Migrated is how many of the entries, from the first, have been copied
+	// to order documents
+	Migrated int `json:"migrated,omitempty"`
 }
 
-// Document is a struct that contains the packages and order
+// Document is a struct that contains the packages, order and parcels, or the
+// lines of a multi-line order, and the strategy that packed them
 type Document struct {
-	Packages model.Packages `json:"packages"`
-	Order    model.Order    `json:"order"`
+	Packages  model.Packages        `json:"packages"`
+	Order     model.Order           `json:"order"`
+	Shipments []model.Shipment      `json:"shipments,omitempty"`
+	MultiLine *model.MultiLineOrder `json:"multiLine,omitempty"`
+	Strategy  string                `json:"strategy,omitempty"`
+}
+
+// Stock is a struct that contains the number of packages in stock for each size
+type Stock struct {
+	Levels map[int]int `json:"levels"`
+}
+
+// RestoreStock returns packages taken by DecrementStock to the stock levels,
+// for an order that could not be completed
+func RestoreStock(dbManager DBManagerInterface, bucketName, scopeName, collectionName, documentID string, used map[int]int) error {
+	returned := make(map[int]int, len(used))
+	for size, count := range used {
+		returned[size] = -count
+	}
+
+	err := dbManager.DecrementStock(bucketName, scopeName, collectionName, documentID, returned)
+	if err != nil {
+		return fmt.Errorf("failed to restore stock: %w", err)
+	}
+
+	return nil
 }
 
 // User is a struct that contains the user credentials
@@ -55,6 +113,11 @@ func NewDBManager() (*DBManager, error) {
 	return &DBManager{Cluster: cluster}, nil
 }
 
+// Close disconnects from the cluster
+func (db *DBManager) Close() error {
+	return db.Cluster.Close(nil)
+}
+
 // GetDBCreds gets the database credentials
 func (db *DBManager) GetDBCreds() (string, string, string, string, error) {
 	err := godotenv.Load("config.env")
@@ -70,6 +133,20 @@ func (db *DBManager) GetDBCreds() (string, string, string, string, error) {
 	return bucketName, scopeName, collectionName, documentID, nil
 }
 
+// GetStockID gets the ID of the stock levels document
+func GetStockID() string {
+	if id := os.Getenv("STOCK_ID"); id != "" {
+		return id
+	}
+
+	return StockDocumentID
+}
+
+// CatalogueID returns the ID of the document holding the named package catalogue
+func CatalogueID(name string) string {
+	return "catalogue::" + name
+}
+
 // GetDBAminCreds gets the database admin credentials
 func GetDBAminCreds() (string, string, error) {
 	err := godotenv.Load("config.env")
@@ -131,8 +208,8 @@ func InitDB() (*DBManager, error) {
 	return db, nil
 }
 
-// CreateBucketHandler creates a new bucket in the database
-func SetupBucket(dbManager *DBManager) error {
+// SetupBucket sets up the database and writes the user document
+func SetupBucket(dbManager Backend) error {
 	bucketName, scopeName, collectionName, documentID, err := dbManager.GetDBCreds()
 	if err != nil {
 		return fmt.Errorf("failed to get database credentials: %w", err)
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// localConfig reads the database credentials of the backends that run in the
+// server's own process. A missing config.env is not an error for them, so
+// they run without one.
+type localConfig struct{}
+
+// GetDBCreds gets the database credentials
+func (localConfig) GetDBCreds() (string, string, string, string, error) {
+	if err := loadOptionalConfig(); err != nil {
+		return "", "", "", "", err
+	}
+
+	return os.Getenv("BUCKET_NAME"), os.Getenv("SCOPE_NAME"), os.Getenv("COLLECTION_NAME"), os.Getenv("DOCUMENT_ID"), nil
+}
+
+// GetClusterCredentials gets the cluster credentials
+func (localConfig) GetClusterCredentials() (string, string, error) {
+	if err := loadOptionalConfig(); err != nil {
+		return "", "", err
+	}
+
+	return os.Getenv("USERNAME"), os.Getenv("PASSWORD"), nil
+}
+
+// loadOptionalConfig loads config.env if there is one
+func loadOptionalConfig() error {
+	err := godotenv.Load("config.env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load .env file: %w", err)
+	}
+
+	return nil
+}
+
+// setupLocalDB creates the bucket, scope and collection and an empty order
+// history, unless there is one
+func setupLocalDB(dbManager DBManagerInterface, bucketName, scopeName, collectionName, documentID string) error {
+	err := dbManager.CreateBucket(bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	err = dbManager.CreateScope(bucketName, scopeName)
+	if err != nil {
+		return fmt.Errorf("failed to create scope: %w", err)
+	}
+
+	err = dbManager.CreateCollection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to create collection: %w", err)
+	}
+
+	_, _, err = dbManager.GetDocument(bucketName, scopeName, collectionName, documentID)
+	if err == nil {
+		return nil
+	}
+
+	err = dbManager.WriteDocument(bucketName, scopeName, collectionName, documentID, DocumentHistory{History: []Document{}})
+	if err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+
+	return nil
+}
+
+// decrementStock removes the used packages from the JSON stock levels.
+// Sizes without a level are not limited.
+func decrementStock(content []byte, used map[int]int) ([]byte, error) {
+	var stock Stock
+	if err := json.Unmarshal(content, &stock); err != nil {
+		return nil, fmt.Errorf("failed to get stock content: %w", err)
+	}
+
+	for size, count := range used {
+		level, ok := stock.Levels[size]
+		if !ok {
+			continue
+		}
+
+		if level < count {
+			return nil, fmt.Errorf("%w: %d packages of size %d left, %d needed", ErrInsufficientStock, level, size, count)
+		}
+		stock.Levels[size] = level - count
+	}
+
+	data, err := json.Marshal(stock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update stock: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,323 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
+)
+
+// MemoryDBManager keeps every document in memory, namespaced by bucket, scope
+// and collection like Couchbase. Documents are stored as JSON, so reads
+// return copies and behave as they would against a cluster. Nothing survives
+// a restart.
+type MemoryDBManager struct {
+	localConfig
+	mu      sync.Mutex
+	buckets map[string]map[string]map[string]map[string]memoryDocument
+	admins  map[string]string
+	cas     Cas
+}
+
+// memoryDocument is a stored document and the CAS of its latest version
+type memoryDocument struct {
+	content []byte
+	cas     Cas
+}
+
+// NewMemoryDBManager creates an empty MemoryDBManager
+func NewMemoryDBManager() *MemoryDBManager {
+	return &MemoryDBManager{
+		buckets: make(map[string]map[string]map[string]map[string]memoryDocument),
+		admins:  make(map[string]string),
+	}
+}
+
+// Close does nothing, as there is nothing to release
+func (db *MemoryDBManager) Close() error {
+	return nil
+}
+
+// CreateAdminUser records an admin user with a hash of the password
+func (db *MemoryDBManager) CreateAdminUser(username, password string) error {
+	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.admins[username] = hash
+	return nil
+}
+
+// SetupDB creates the bucket, scope and collection and an empty order history
+func (db *MemoryDBManager) SetupDB(bucketName, scopeName, collectionName, documentID string) error {
+	return setupLocalDB(db, bucketName, scopeName, collectionName, documentID)
+}
+
+// CreateBucket creates a bucket, unless it already exists
+func (db *MemoryDBManager) CreateBucket(bucketName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.buckets[bucketName]; !ok {
+		db.buckets[bucketName] = make(map[string]map[string]map[string]memoryDocument)
+	}
+	return nil
+}
+
+// CreateScope creates a scope in a bucket, unless it already exists
+func (db *MemoryDBManager) CreateScope(bucketName, scopeName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	bucket, ok := db.buckets[bucketName]
+	if !ok {
+		return fmt.Errorf("failed to create scope: %w: bucket %s", ErrCollectionNotFound, bucketName)
+	}
+
+	if _, ok := bucket[scopeName]; !ok {
+		bucket[scopeName] = make(map[string]map[string]memoryDocument)
+	}
+	return nil
+}
+
+// CreateCollection creates a collection in a scope, unless it already exists
+func (db *MemoryDBManager) CreateCollection(bucketName, scopeName, collectionName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	scope, ok := db.buckets[bucketName][scopeName]
+	if !ok {
+		return fmt.Errorf("failed to create collection: %w: scope %s.%s", ErrCollectionNotFound, bucketName, scopeName)
+	}
+
+	if _, ok := scope[collectionName]; !ok {
+		scope[collectionName] = make(map[string]memoryDocument)
+	}
+	return nil
+}
+
+// GetDocument gets the document from the database with its CAS
+func (db *MemoryDBManager) GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, Cas, error) {
+	var document DocumentHistory
+	cas, err := db.get(bucketName, scopeName, collectionName, documentID, &document)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get document: %w", err)
+	}
+
+	return &document, cas, nil
+}
+
+// GetUser gets the user from the database
+func (db *MemoryDBManager) GetUser(bucketName, scopeName, collectionName, documentID string) (*User, error) {
+	var user User
+	if _, err := db.get(bucketName, scopeName, collectionName, documentID, &user); err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// GetStock gets the stock levels from the database
+func (db *MemoryDBManager) GetStock(bucketName, scopeName, collectionName, documentID string) (*Stock, error) {
+	var stock Stock
+	if _, err := db.get(bucketName, scopeName, collectionName, documentID, &stock); err != nil {
+		return nil, fmt.Errorf("failed to get stock: %w", err)
+	}
+
+	return &stock, nil
+}
+
+// GetCatalogue gets a stored package catalogue from the database
+func (db *MemoryDBManager) GetCatalogue(bucketName, scopeName, collectionName, documentID string) (*model.Packages, error) {
+	var packages model.Packages
+	if _, err := db.get(bucketName, scopeName, collectionName, documentID, &packages); err != nil {
+		return nil, fmt.Errorf("failed to get catalogue: %w", err)
+	}
+
+	return &packages, nil
+}
+
+// WriteDocument writes a document, replacing any document with the same ID
+func (db *MemoryDBManager) WriteDocument(bucketName, scopeName, collectionName, documentID string, content interface{}) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	collection, err := db.collection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+
+	db.store(collection, documentID, data)
+	return nil
+}
+
+// ReplaceDocument replaces a document, but only if its CAS still matches the
+// one it was read with. Otherwise it fails with ErrCasMismatch.
+func (db *MemoryDBManager) ReplaceDocument(bucketName, scopeName, collectionName, documentID string, content interface{}, cas Cas) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to replace document: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	collection, err := db.collection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to replace document: %w", err)
+	}
+
+	stored, ok := collection[documentID]
+	switch {
+	case !ok:
+		return fmt.Errorf("failed to replace document %s: %w", documentID, ErrNotFound)
+	case stored.cas != cas:
+		return fmt.Errorf("failed to replace document %s: %w", documentID, ErrCasMismatch)
+	}
+
+	db.store(collection, documentID, data)
+	return nil
+}
+
+// InsertOrders stores each order as its own document, with the
+// BatchDocument of a keyed batch. Nothing is stored if any of their IDs is
+// already taken.
+func (db *MemoryDBManager) InsertOrders(bucketName, scopeName, collectionName string, orders []OrderDocument) error {
+	writes := orderWrites(orders)
+	contents := make([][]byte, len(writes))
+	for i, write := range writes {
+		data, err := json.Marshal(write.content)
+		if err != nil {
+			return fmt.Errorf("failed to insert order %s: %w", write.id, err)
+		}
+		contents[i] = data
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	collection, err := db.collection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to insert orders: %w", err)
+	}
+
+	for _, write := range writes {
+		if _, ok := collection[write.id]; ok {
+			return fmt.Errorf("failed to insert order %s: %w", write.id, ErrDocumentExists)
+		}
+	}
+
+	for i, write := range writes {
+		db.store(collection, write.id, contents[i])
+	}
+	return nil
+}
+
+// ListOrders lists up to limit orders with IDs after the given one, in the
+// order they were placed. An empty after starts from the first order.
+func (db *MemoryDBManager) ListOrders(bucketName, scopeName, collectionName, after string, limit int) ([]OrderDocument, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	collection, err := db.collection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+
+	var ids []string
+	for id := range collection {
+		if strings.HasPrefix(id, OrderIDPrefix) && id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	orders := []OrderDocument{}
+	for _, id := range ids[:min(len(ids), max(limit, 0))] {
+		var order OrderDocument
+		if err := json.Unmarshal(collection[id].content, &order); err != nil {
+			return nil, fmt.Errorf("failed to get order content: %w", err)
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// DecrementStock removes the used packages from the stock levels, or returns
+// them for negative counts. Nothing is removed if any size has too few
+// packages left.
+func (db *MemoryDBManager) DecrementStock(bucketName, scopeName, collectionName, documentID string, used map[int]int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	collection, err := db.collection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to get stock: %w", err)
+	}
+
+	stored, ok := collection[documentID]
+	if !ok {
+		return fmt.Errorf("failed to get stock %s: %w", documentID, ErrNotFound)
+	}
+
+	data, err := decrementStock(stored.content, used)
+	if err != nil {
+		return err
+	}
+
+	db.store(collection, documentID, data)
+	return nil
+}
+
+// get decodes the stored document into content and returns its CAS
+func (db *MemoryDBManager) get(bucketName, scopeName, collectionName, documentID string, content interface{}) (Cas, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	collection, err := db.collection(bucketName, scopeName, collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	stored, ok := collection[documentID]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, documentID)
+	}
+
+	if err := json.Unmarshal(stored.content, content); err != nil {
+		return 0, fmt.Errorf("failed to get content of %s: %w", documentID, err)
+	}
+
+	return stored.cas, nil
+}
+
+// collection returns the documents of a collection. The caller must hold mu.
+func (db *MemoryDBManager) collection(bucketName, scopeName, collectionName string) (map[string]memoryDocument, error) {
+	collection, ok := db.buckets[bucketName][scopeName][collectionName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s.%s.%s", ErrCollectionNotFound, bucketName, scopeName, collectionName)
+	}
+
+	return collection, nil
+}
+
+// store saves a new version of a document with a new CAS. The caller must
+// hold mu.
+func (db *MemoryDBManager) store(collection map[string]memoryDocument, documentID string, content []byte) {
+	db.cas++
+	collection[documentID] = memoryDocument{content: content, cas: db.cas}
+}
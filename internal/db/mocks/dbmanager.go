@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"github.com/mxnyawi/gymSharkTask/internal/db"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -9,9 +10,9 @@ type MockDBManager struct {
 	mock.Mock
 }
 
-func (m *MockDBManager) GetDocument(bucketName, scopeName, collectionName, documentID string) (*db.DocumentHistory, error) {
+func (m *MockDBManager) GetDocument(bucketName, scopeName, collectionName, documentID string) (*db.DocumentHistory, db.Cas, error) {
 	args := m.Called(bucketName, scopeName, collectionName, documentID)
-	return args.Get(0).(*db.DocumentHistory), args.Error(1)
+	return args.Get(0).(*db.DocumentHistory), args.Get(1).(db.Cas), args.Error(2)
 }
 
 func (m *MockDBManager) GetUser(bucketName, scopeName, collectionName, documentID string) (*db.User, error) {
@@ -24,6 +25,11 @@ func (m *MockDBManager) WriteDocument(bucket, scope, collection, id string, data
 	return args.Error(0)
 }
 
+func (m *MockDBManager) ReplaceDocument(bucket, scope, collection, id string, data interface{}, cas db.Cas) error {
+	args := m.Called(bucket, scope, collection, id, data, cas)
+	return args.Error(0)
+}
+
 func (m *MockDBManager) GetDBCreds() (string, string, string, string, error) {
 	args := m.Called()
 	return args.String(0), args.String(1), args.String(2), args.String(3), args.Error(4)
@@ -53,3 +59,28 @@ func (m *MockDBManager) GetClusterCredentials() (string, string, error) {
 	args := m.Called()
 	return args.String(0), args.String(1), args.Error(2)
 }
+
+func (m *MockDBManager) GetStock(bucketName, scopeName, collectionName, documentID string) (*db.Stock, error) {
+	args := m.Called(bucketName, scopeName, collectionName, documentID)
+	return args.Get(0).(*db.Stock), args.Error(1)
+}
+
+func (m *MockDBManager) DecrementStock(bucketName, scopeName, collectionName, documentID string, used map[int]int) error {
+	args := m.Called(bucketName, scopeName, collectionName, documentID, used)
+	return args.Error(0)
+}
+
+func (m *MockDBManager) GetCatalogue(bucketName, scopeName, collectionName, documentID string) (*model.Packages, error) {
+	args := m.Called(bucketName, scopeName, collectionName, documentID)
+	return args.Get(0).(*model.Packages), args.Error(1)
+}
+
+func (m *MockDBManager) InsertOrders(bucketName, scopeName, collectionName string, orders []db.OrderDocument) error {
+	args := m.Called(bucketName, scopeName, collectionName, orders)
+	return args.Error(0)
+}
+
+func (m *MockDBManager) ListOrders(bucketName, scopeName, collectionName, after string, limit int) ([]db.OrderDocument, error) {
+	args := m.Called(bucketName, scopeName, collectionName, after, limit)
+	return args.Get(0).([]db.OrderDocument), args.Error(1)
+}
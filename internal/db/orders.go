@@ -0,0 +1,195 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OrderIDPrefix starts the ID of every order document, so orders can be told
+// apart from users, stock and catalogues in the same collection
+const OrderIDPrefix = "order::"
+
+const (
+	// historyPageSize is how many orders ScanHistory lists at a time
+	historyPageSize = 500
+	// maxHistoryRetries is how often MigrateHistory retries after losing a race
+	maxHistoryRetries = 5
+)
+
+// OrderDocument is a stored order: the Document it was packed from, with its
+// ID and the time it was placed. Orders split out of the old history document
+// are marked as migrated, and their CreatedAt is the time of the migration.
+// Orders stored by a batch with a client key carry it as Batch.
+type OrderDocument struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Migrated  bool      `json:"migrated,omitempty"`
+	Batch     string    `json:"batch,omitempty"`
+	Document
+}
+
+// BatchDocument records the orders a batch with a client key was stored as.
+// It is written with the orders, so a retried batch finds it and fails with
+// ErrDocumentExists instead of storing the orders twice.
+type BatchDocument struct {
+	Batch  string   `json:"batch"`
+	Orders []string `json:"orders"`
+}
+
+// BatchDocumentID returns the ID of the BatchDocument of a batch key
+func BatchDocumentID(batch string) string {
+	return "batch::" + batch
+}
+
+// documentWrite is a document to insert and its ID
+type documentWrite struct {
+	id      string
+	content interface{}
+}
+
+// orderWrites returns the documents InsertOrders inserts for the orders: each
+// order and, when they carry a batch key, their BatchDocument
+func orderWrites(orders []OrderDocument) []documentWrite {
+	writes := make([]documentWrite, 0, len(orders)+1)
+	for _, order := range orders {
+		writes = append(writes, documentWrite{id: order.ID, content: order})
+	}
+
+	if len(orders) > 0 && orders[0].Batch != "" {
+		batch := BatchDocument{Batch: orders[0].Batch}
+		for _, order := range orders {
+			batch.Orders = append(batch.Orders, order.ID)
+		}
+		writes = append(writes, documentWrite{id: BatchDocumentID(batch.Batch), content: batch})
+	}
+
+	return writes
+}
+
+// NewOrderDocument gives the document a new order ID, placed now
+func NewOrderDocument(document Document) OrderDocument {
+	now := time.Now().UTC()
+	return OrderDocument{ID: NewOrderID(now), CreatedAt: now, Document: document}
+}
+
+// NewOrderID returns a new order ID for an order placed at createdAt. IDs are
+// the time in nanoseconds followed by a random suffix, both in fixed-width hex,
+// so they sort in the order the orders were placed.
+func NewOrderID(createdAt time.Time) string {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate order ID: %v", err))
+	}
+
+	return fmt.Sprintf("%s%016x-%08x", OrderIDPrefix, createdAt.UnixNano(), binary.BigEndian.Uint32(suffix[:]))
+}
+
+// LegacyOrderID returns the ID of the order at index in the old history
+// document. The IDs sort before those of every new order, in history order.
+func LegacyOrderID(index int) string {
+	return fmt.Sprintf("%s%016x-%08x", OrderIDPrefix, 0, index)
+}
+
+// ScanHistory calls fn with every stored order, oldest first. The orders are
+// listed a page at a time, so the history is never held in memory at once.
+// It stops at the first error fn returns.
+func ScanHistory(dbManager DBManagerInterface, bucketName, scopeName, collectionName string, fn func(Document) error) error {
+	after := ""
+	for {
+		orders, err := dbManager.ListOrders(bucketName, scopeName, collectionName, after, historyPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, order := range orders {
+			if err := fn(order.Document); err != nil {
+				return err
+			}
+		}
+
+		if len(orders) < historyPageSize {
+			return nil
+		}
+		after = orders[len(orders)-1].ID
+	}
+}
+
+// OrderDemand is what the order history says about demand: the amounts of
+// the single-line orders, oldest first, and the package sizes of the latest
+// one, nil if there is none
+type OrderDemand struct {
+	Amounts     []int
+	LatestSizes []int
+}
+
+// ReadDemand scans the order history for its OrderDemand
+func ReadDemand(dbManager DBManagerInterface, bucketName, scopeName, collectionName string) (OrderDemand, error) {
+	var demand OrderDemand
+	err := ScanHistory(dbManager, bucketName, scopeName, collectionName, func(document Document) error {
+		if document.MultiLine != nil {
+			return nil
+		}
+
+		if document.Order.Amount > 0 {
+			demand.Amounts = append(demand.Amounts, document.Order.Amount)
+		}
+		if len(document.Packages.Sizes) > 0 {
+			demand.LatestSizes = document.Packages.Sizes
+		}
+		return nil
+	})
+	if err != nil {
+		return OrderDemand{}, err
+	}
+
+	return demand, nil
+}
+
+// MigrateHistory copies the entries of the old history document that have
+// not been migrated yet to order documents, and returns how many it copied.
+// The history document is kept and only records how many entries were
+// migrated, replaced by CAS: if an older server appends an order meanwhile,
+// the history is read again and the new entries are migrated too. Each entry
+// always gets the same ID, so nothing is copied twice.
+func MigrateHistory(dbManager DBManagerInterface, bucketName, scopeName, collectionName, documentID string) (int, error) {
+	migratedAt := time.Now().UTC()
+	copied, written := 0, 0
+
+	for attempt := 0; attempt < maxHistoryRetries; attempt++ {
+		history, cas, err := dbManager.GetDocument(bucketName, scopeName, collectionName, documentID)
+		if err != nil {
+			return copied, fmt.Errorf("failed to get order history: %w", err)
+		}
+
+		for i := max(history.Migrated, written); i < len(history.History); i++ {
+			order := OrderDocument{ID: LegacyOrderID(i), CreatedAt: migratedAt, Migrated: true, Document: history.History[i]}
+
+			err = dbManager.WriteDocument(bucketName, scopeName, collectionName, order.ID, order)
+			if err != nil {
+				return copied, fmt.Errorf("failed to write order %s: %w", order.ID, err)
+			}
+			copied++
+		}
+		written = len(history.History)
+
+		if history.Migrated == written {
+			return copied, nil
+		}
+
+		history.Migrated = written
+		err = dbManager.ReplaceDocument(bucketName, scopeName, collectionName, documentID, history, cas)
+		switch {
+		case err == nil:
+			return copied, nil
+		case errors.Is(err, ErrCasMismatch):
+			continue
+		default:
+			return copied, fmt.Errorf("failed to update order history: %w", err)
+		}
+	}
+
+	return copied, fmt.Errorf("failed to update order history: gave up after %d conflicting updates", maxHistoryRetries)
+}
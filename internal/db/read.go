@@ -1,29 +1,34 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/couchbase/gocb/v2"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
 )
 
-// GetDocument gets the document from the database
-func (db *DBManager) GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, error) {
+// GetDocument gets the document from the database with its CAS
+func (db *DBManager) GetDocument(bucketName, scopeName, collectionName, documentID string) (*DocumentHistory, Cas, error) {
 	collection := db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName)
 
 	var document DocumentHistory
 	docOut, err := collection.Get(documentID, &gocb.GetOptions{})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, 0, fmt.Errorf("failed to get document %s: %w", documentID, ErrNotFound)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get document: %w", err)
+		return nil, 0, fmt.Errorf("failed to get document: %w", err)
 	}
 
 	err = docOut.Content(&document)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get document content: %w", err)
+		return nil, 0, fmt.Errorf("failed to get document content: %w", err)
 	}
 
 	log.Println("Document retrieved successfully")
-	return &document, nil
+	return &document, Cas(docOut.Cas()), nil
 }
 
 // GetUser gets the user from the database
@@ -32,6 +37,9 @@ func (db *DBManager) GetUser(bucketName, scopeName, collectionName, documentID s
 
 	var document User
 	docOut, err := collection.Get(documentID, &gocb.GetOptions{})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, fmt.Errorf("failed to get user %s: %w", documentID, ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -44,3 +52,77 @@ func (db *DBManager) GetUser(bucketName, scopeName, collectionName, documentID s
 	log.Println("User retrieved successfully")
 	return &document, nil
 }
+
+// GetStock gets the stock levels from the database
+func (db *DBManager) GetStock(bucketName, scopeName, collectionName, documentID string) (*Stock, error) {
+	collection := db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName)
+
+	var stock Stock
+	docOut, err := collection.Get(documentID, &gocb.GetOptions{})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, fmt.Errorf("failed to get stock %s: %w", documentID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock: %w", err)
+	}
+
+	err = docOut.Content(&stock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock content: %w", err)
+	}
+
+	log.Println("Stock retrieved successfully")
+	return &stock, nil
+}
+
+// GetCatalogue gets a stored package catalogue from the database
+func (db *DBManager) GetCatalogue(bucketName, scopeName, collectionName, documentID string) (*model.Packages, error) {
+	collection := db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName)
+
+	var packages model.Packages
+	docOut, err := collection.Get(documentID, &gocb.GetOptions{})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, fmt.Errorf("failed to get catalogue %s: %w", documentID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalogue: %w", err)
+	}
+
+	err = docOut.Content(&packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalogue content: %w", err)
+	}
+
+	log.Println("Catalogue retrieved successfully")
+	return &packages, nil
+}
+
+// ListOrders lists up to limit orders with IDs after the given one, in the
+// order they were placed. An empty after starts from the first order.
+func (db *DBManager) ListOrders(bucketName, scopeName, collectionName, after string, limit int) ([]OrderDocument, error) {
+	statement := fmt.Sprintf("SELECT RAW o FROM `%s` AS o WHERE META(o).id LIKE $prefix AND META(o).id > $after ORDER BY META(o).id LIMIT $limit", collectionName)
+	result, err := db.Cluster.Bucket(bucketName).Scope(scopeName).Query(statement, &gocb.QueryOptions{
+		NamedParameters: map[string]interface{}{"prefix": OrderIDPrefix + "%", "after": after, "limit": limit},
+		ScanConsistency: gocb.QueryScanConsistencyRequestPlus,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer result.Close()
+
+	orders := []OrderDocument{}
+	for result.Next() {
+		var order OrderDocument
+		if err := result.Row(&order); err != nil {
+			return nil, fmt.Errorf("failed to get order content: %w", err)
+		}
+		orders = append(orders, order)
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+
+	log.Println("Orders listed successfully")
+	return orders, nil
+}
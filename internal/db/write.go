@@ -1,6 +1,8 @@
 package db
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -43,7 +45,14 @@ func (db *DBManager) SetupDB(bucketName, scopeName, collectionName, documentID s
 		return fmt.Errorf("failed to create collection: %w", err)
 	}
 
-	_, err = db.GetDocument(bucketName, scopeName, collectionName, documentID)
+	// Orders are listed by their IDs
+	err = db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName).QueryIndexes().
+		CreatePrimaryIndex(&gocb.CreatePrimaryQueryIndexOptions{IgnoreIfExists: true})
+	if err != nil {
+		return fmt.Errorf("failed to create primary index: %w", err)
+	}
+
+	_, _, err = db.GetDocument(bucketName, scopeName, collectionName, documentID)
 	if err == nil {
 		log.Println("Document already exists")
 		return nil
@@ -128,3 +137,110 @@ func (db *DBManager) WriteDocument(bucketName, scopeName, collectionName, docume
 	log.Println("Document written successfully")
 	return nil
 }
+
+// ReplaceDocument replaces a document, but only if its CAS still matches the
+// one it was read with. Otherwise it fails with ErrCasMismatch and the caller
+// should read the document again.
+func (db *DBManager) ReplaceDocument(bucketName, scopeName, collectionName, documentID string, content interface{}, cas Cas) error {
+	collection := db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName)
+
+	_, err := collection.Replace(documentID, content, &gocb.ReplaceOptions{Cas: gocb.Cas(cas), Timeout: 10 * time.Second})
+	switch {
+	case err == nil:
+		log.Println("Document replaced successfully")
+		return nil
+	case errors.Is(err, gocb.ErrCasMismatch):
+		return fmt.Errorf("failed to replace document %s: %w", documentID, ErrCasMismatch)
+	case errors.Is(err, gocb.ErrDocumentNotFound):
+		return fmt.Errorf("failed to replace document %s: %w", documentID, ErrNotFound)
+	default:
+		return fmt.Errorf("failed to replace document: %w", err)
+	}
+}
+
+// InsertOrders stores each order as its own document, with the
+// BatchDocument of a keyed batch, in one transaction: either every document
+// is inserted or none is. A single document needs no transaction and is
+// inserted on its own. Orders are inserted, never replaced, so an ID that is
+// already taken is an error.
+func (db *DBManager) InsertOrders(bucketName, scopeName, collectionName string, orders []OrderDocument) error {
+	collection := db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName)
+
+	writes := orderWrites(orders)
+	if len(writes) == 1 {
+		_, err := collection.Insert(writes[0].id, writes[0].content, &gocb.InsertOptions{Timeout: 10 * time.Second})
+		if errors.Is(err, gocb.ErrDocumentExists) {
+			err = fmt.Errorf("%w: %s", ErrDocumentExists, writes[0].id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to insert orders: %w", err)
+		}
+
+		log.Println("Orders written successfully")
+		return nil
+	}
+
+	_, err := db.Cluster.Transactions().Run(func(ctx *gocb.TransactionAttemptContext) error {
+		for _, write := range writes {
+			_, err := ctx.Insert(collection, write.id, write.content)
+			if errors.Is(err, gocb.ErrDocumentExists) {
+				return fmt.Errorf("%w: %s", ErrDocumentExists, write.id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, &gocb.TransactionOptions{Timeout: 10 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to insert orders: %w", err)
+	}
+
+	log.Println("Orders written successfully")
+	return nil
+}
+
+// maxStockRetries is how often DecrementStock retries after losing a race
+const maxStockRetries = 5
+
+// DecrementStock removes the used packages from the stock levels, or returns
+// them for negative counts. The levels are replaced with CAS so concurrent
+// orders cannot both take the last packages.
+func (db *DBManager) DecrementStock(bucketName, scopeName, collectionName, documentID string, used map[int]int) error {
+	collection := db.Cluster.Bucket(bucketName).Scope(scopeName).Collection(collectionName)
+
+	for attempt := 0; attempt < maxStockRetries; attempt++ {
+		docOut, err := collection.Get(documentID, &gocb.GetOptions{})
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			return fmt.Errorf("failed to get stock %s: %w", documentID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get stock: %w", err)
+		}
+
+		var content json.RawMessage
+		err = docOut.Content(&content)
+		if err != nil {
+			return fmt.Errorf("failed to get stock content: %w", err)
+		}
+
+		data, err := decrementStock(content, used)
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.Replace(documentID, json.RawMessage(data), &gocb.ReplaceOptions{Cas: docOut.Cas(), Timeout: 10 * time.Second})
+		switch {
+		case err == nil:
+			log.Println("Stock updated successfully")
+			return nil
+		case errors.Is(err, gocb.ErrCasMismatch):
+			continue
+		default:
+			return fmt.Errorf("failed to update stock: %w", err)
+		}
+	}
+
+	return fmt.Errorf("failed to update stock: gave up after %d conflicting updates", maxStockRetries)
+}
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxAlternatives is the most alternative packings Alternatives returns
+	MaxAlternatives = 10
+	// maxAlternativeSpan is the most totals the search for alternatives
+	// covers before setting aside pivot packages
+	maxAlternativeSpan = 1 << 20
+	// maxAlternativeSteps bounds the search for alternatives, so amounts with
+	// few packings cannot make it enumerate a huge number of dead ends
+	maxAlternativeSteps = 1 << 20
+)
+
+// ErrInvalidAlternatives is returned when the number of alternatives asked
+// for is not between 1 and MaxAlternatives
+var ErrInvalidAlternatives = errors.New("invalid number of alternatives")
+
+// Alternatives returns up to k packings of amount, best first: the least
+// overshoot, then the fewest packages. Only packings without a package that
+// could be dropped while still covering the amount are listed, so the first
+// is the packing Solve returns and the rest are the next best. Fewer than k
+// are returned when the amount has fewer such packings.
+//
+// Amounts beyond about a million items are searched among the packings that
+// share the pivot packages the best packing is guaranteed to have.
+func (p Packages) Alternatives(amount, k int) ([]Order, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateAmount(amount); err != nil {
+		return nil, err
+	}
+
+	if k < 1 || k > MaxAlternatives {
+		return nil, fmt.Errorf("%w: %d is not between 1 and %d", ErrInvalidAlternatives, k, MaxAlternatives)
+	}
+
+	largest := 0
+	for _, size := range p.Sizes {
+		largest = max(largest, size)
+	}
+
+	// Only set aside as many pivot packages as keep the search in bounds
+	r := newReduction(p.Sizes, nil, ObjectivePackages)
+	needed := max(0, amount+largest-1-maxAlternativeSpan+r.pivot-1) / r.pivot
+	aside := min(r.setAside(amount), needed)
+	remainder := amount - aside*r.pivot
+
+	span := remainder + largest - 1
+	if span > maxTableSpan {
+		return nil, fmt.Errorf("%w: sizes %v need a table of %d totals, the limit is %d",
+			ErrAmountTooLarge, p.Sizes, span, maxTableSpan)
+	}
+
+	table, err := newPackingTable(context.Background(), p.Sizes, nil, span)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &alternativeSearch{
+		table: table,
+		most:  mostPackages(table.sizes, span),
+		picks: make(map[int]int),
+	}
+
+	var orders []Order
+	for total := remainder; total <= span && len(orders) < k && s.steps < maxAlternativeSteps; total++ {
+		if !table.reachable(total) {
+			continue
+		}
+
+		// A package no larger than the overshoot could be dropped
+		s.smallest = sort.SearchInts(table.sizes, total-remainder+1)
+		if s.smallest == len(table.sizes) {
+			continue
+		}
+
+		fewest := max(int(table.counts[total]), (total+largest-1)/largest)
+		most := min(int(s.most[total]), total/table.sizes[s.smallest])
+		for count := fewest; count <= most && len(orders) < k && s.steps < maxAlternativeSteps; count++ {
+			s.found = nil
+			s.search(total, count, len(table.sizes)-1, k-len(orders))
+
+			for _, counts := range s.found {
+				if aside > 0 {
+					counts[r.pivot] += aside
+				}
+				orders = append(orders, p.newOrder(amount, newPackingFromCounts(amount, counts)))
+			}
+		}
+	}
+
+	return orders, nil
+}
+
+// alternativeSearch enumerates the packings of a total with a given number of
+// packages, taking as many of the largest sizes as possible first
+type alternativeSearch struct {
+	table    *packingTable
+	most     []int32 // most packages per total, -1 when unreachable
+	smallest int     // index of the smallest size that may be used
+	picks    map[int]int
+	found    []map[int]int
+	steps    int
+}
+
+// search adds up to limit packings of total with exactly count packages of
+// the sizes from table.sizes[smallest] up to table.sizes[i]
+func (s *alternativeSearch) search(total, count, i, limit int) {
+	if total == 0 && count == 0 {
+		found := make(map[int]int, len(s.picks))
+		for size, n := range s.picks {
+			found[size] = n
+		}
+		s.found = append(s.found, found)
+		return
+	}
+
+	if i < s.smallest {
+		return
+	}
+
+	// The packages left after taking n of this size must fit between the
+	// smallest and the largest of the sizes below it
+	size := s.table.sizes[i]
+	lowest, highest := min(total/size, count), 0
+	if i == s.smallest {
+		if total != count*size {
+			return
+		}
+		highest = count
+	} else {
+		lo, hi := s.table.sizes[s.smallest], s.table.sizes[i-1]
+		lowest = min(lowest, (total-count*lo)/(size-lo))
+		highest = max(0, (total-count*hi+size-hi-1)/(size-hi))
+	}
+
+	for n := lowest; n >= highest; n-- {
+		if len(s.found) >= limit || s.steps >= maxAlternativeSteps {
+			return
+		}
+		s.steps++
+
+		prev, left := total-n*size, count-n
+		if !s.table.reachable(prev) || int(s.table.counts[prev]) > left || int(s.most[prev]) < left {
+			continue
+		}
+
+		if n > 0 {
+			s.picks[size] = n
+		}
+		s.search(prev, left, i-1, limit)
+		delete(s.picks, size)
+	}
+}
+
+// mostPackages returns the most packages that make up every total from 0 to
+// span, or -1 for totals that cannot be made
+func mostPackages(sizes []int, span int) []int32 {
+	most := make([]int32, span+1)
+	for total := 1; total <= span; total++ {
+		most[total] = -1
+		for _, size := range sizes {
+			if size > total {
+				break
+			}
+
+			if prev := most[total-size]; prev >= 0 && prev+1 > most[total] {
+				most[total] = prev + 1
+			}
+		}
+	}
+
+	return most
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxAnalyzeAmount is the largest amount Analyze looks at
+	MaxAnalyzeAmount = 1_000_000
+	// MaxCurvePoints is the most buckets an Analysis splits its waste curve into
+	MaxCurvePoints = 100
+)
+
+// ErrInvalidRange is returned when the amounts to analyse are not a range
+// between 1 and MaxAnalyzeAmount
+var ErrInvalidRange = errors.New("invalid range of amounts")
+
+// Analysis describes how a set of package sizes behaves over a range of order
+// amounts
+type Analysis struct {
+	Sizes []int `json:"sizes"`
+	GCD   int   `json:"gcd"`
+	// Frobenius is the largest total that cannot be made from whole packages.
+	// It is nil when the GCD is above 1, as then no multiple of the sizes
+	// reaches the totals between the multiples of the GCD.
+	Frobenius *int `json:"frobenius"`
+	From      int  `json:"from"`
+	To        int  `json:"to"`
+	// WorstOvershoot is the most items shipped over an amount in the range,
+	// first seen at WorstAmount
+	WorstOvershoot   int     `json:"worstOvershoot"`
+	WorstAmount      int     `json:"worstAmount"`
+	AverageOvershoot float64 `json:"averageOvershoot"`
+	// UnusedSizes are the sizes no optimal packing of an amount in the range uses
+	UnusedSizes []int        `json:"unusedSizes"`
+	Curve       []WastePoint `json:"curve"`
+}
+
+// WastePoint is the overshoot over one bucket of amounts of the waste curve
+type WastePoint struct {
+	From             int     `json:"from"`
+	To               int     `json:"to"`
+	WorstOvershoot   int     `json:"worstOvershoot"`
+	AverageOvershoot float64 `json:"averageOvershoot"`
+}
+
+// Analyze reports how the package sizes pack every amount from from to to:
+// the GCD and Frobenius number of the sizes, the overshoot of the optimal
+// packings and the sizes they never need
+func (p Packages) Analyze(from, to int) (Analysis, error) {
+	if err := p.Validate(); err != nil {
+		return Analysis{}, err
+	}
+
+	if from < 1 || to < from || to > MaxAnalyzeAmount {
+		return Analysis{}, fmt.Errorf("%w: %d to %d, amounts must be between 1 and %d",
+			ErrInvalidRange, from, to, MaxAnalyzeAmount)
+	}
+
+	sizes := append([]int(nil), p.Sizes...)
+	sort.Ints(sizes)
+
+	a := Analysis{Sizes: sizes, From: from, To: to, UnusedSizes: []int{}, Curve: []WastePoint{}}
+	for _, size := range sizes {
+		a.GCD = gcd(a.GCD, size)
+	}
+
+	if a.GCD == 1 {
+		frobenius := frobeniusNumber(sizes)
+		a.Frobenius = &frobenius
+	}
+
+	largest := sizes[len(sizes)-1]
+	table, err := newPackingTable(context.Background(), sizes, nil, to+largest-1)
+	if err != nil {
+		return Analysis{}, err
+	}
+
+	// next[i] is the smallest reachable total from from+i onwards
+	next := make([]int, to+largest-from)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i] = -1
+		if table.reachable(from + i) {
+			next[i] = from + i
+		} else if i+1 < len(next) {
+			next[i] = next[i+1]
+		}
+	}
+
+	amounts := to - from + 1
+	bucket := (amounts + MaxCurvePoints - 1) / MaxCurvePoints
+	total := 0
+	for start := from; start <= to; start += bucket {
+		point := WastePoint{From: start, To: min(start+bucket-1, to)}
+		sum := 0
+		for amount := point.From; amount <= point.To; amount++ {
+			overshoot := next[amount-from] - amount
+			sum += overshoot
+			point.WorstOvershoot = max(point.WorstOvershoot, overshoot)
+			if overshoot > a.WorstOvershoot {
+				a.WorstOvershoot, a.WorstAmount = overshoot, amount
+			}
+		}
+
+		point.AverageOvershoot = float64(sum) / float64(point.To-point.From+1)
+		a.Curve = append(a.Curve, point)
+		total += sum
+	}
+	a.AverageOvershoot = float64(total) / float64(amounts)
+	if a.WorstAmount == 0 {
+		a.WorstAmount = from
+	}
+
+	// A size is used by some optimal packing when a best total can drop it and
+	// keep the fewest packages of what is left
+	used := make([]bool, len(sizes))
+	for i, best := range next[:amounts] {
+		if i > 0 && best == next[i-1] {
+			continue
+		}
+
+		for j, size := range sizes {
+			if size <= best && table.counts[best-size] == table.counts[best]-1 {
+				used[j] = true
+			}
+		}
+	}
+
+	for i, size := range sizes {
+		if !used[i] {
+			a.UnusedSizes = append(a.UnusedSizes, size)
+		}
+	}
+
+	return a, nil
+}
+
+// frobeniusNumber returns the largest total that cannot be made from the
+// ascending sizes, whose GCD must be 1. It finds the smallest reachable total
+// in every residue class modulo the smallest size with the round robin
+// algorithm of Böcker and Lipták.
+func frobeniusNumber(sizes []int) int {
+	base := sizes[0]
+	if base == 1 {
+		return -1
+	}
+
+	const unreachable = -1
+	smallest := make([]int, base)
+	for i := range smallest {
+		smallest[i] = unreachable
+	}
+	smallest[0] = 0
+
+	for _, size := range sizes[1:] {
+		d := gcd(base, size)
+		for residue := 0; residue < d; residue++ {
+			// Start the cycle through this residue class at its smallest total
+			n := unreachable
+			for q := residue; q < base; q += d {
+				if smallest[q] != unreachable && (n == unreachable || smallest[q] < n) {
+					n = smallest[q]
+				}
+			}
+			if n == unreachable {
+				continue
+			}
+
+			for i := 0; i < base/d-1; i++ {
+				n += size
+				r := n % base
+				if smallest[r] != unreachable && smallest[r] < n {
+					n = smallest[r]
+				}
+				smallest[r] = n
+			}
+		}
+	}
+
+	largest := 0
+	for _, n := range smallest {
+		largest = max(largest, n)
+	}
+
+	return largest - base
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MaxBatchAmounts is the most amounts SolveBatch packs in one call
+const MaxBatchAmounts = 1000
+
+// ErrInvalidBatch is returned when a batch has no amounts or more than
+// MaxBatchAmounts
+var ErrInvalidBatch = errors.New("invalid batch of amounts")
+
+// BatchPacker is implemented by strategies that can share work between the
+// amounts of a batch. Strategies without it pack each amount on its own. A
+// batch stopped by its context returns the best packing of each amount found
+// so far with the error, or no packings if it found none.
+type BatchPacker interface {
+	PackBatch(ctx context.Context, p Packages, amounts []int, opts SolveOptions) ([]Packing, error)
+}
+
+// SolveBatch validates its inputs and packs every amount with the same sizes
+// and options, returning the orders in the order of the amounts. Each order
+// is the one Solve would return for its amount.
+func (p Packages) SolveBatch(amounts []int, opts SolveOptions) ([]Order, error) {
+	return p.SolveBatchContext(context.Background(), amounts, opts)
+}
+
+// SolveBatchContext packs the amounts like SolveBatch, but stops once ctx is
+// done. When opts.Budget runs out first, the amounts not packed by then get
+// the approximate orders of SolveContext.
+func (p Packages) SolveBatchContext(ctx context.Context, amounts []int, opts SolveOptions) ([]Order, error) {
+	if len(amounts) == 0 || len(amounts) > MaxBatchAmounts {
+		return nil, fmt.Errorf("%w: %d amounts, between 1 and %d are allowed",
+			ErrInvalidBatch, len(amounts), MaxBatchAmounts)
+	}
+
+	if err := p.ValidateOrder(amounts[0], opts); err != nil {
+		return nil, err
+	}
+
+	for _, amount := range amounts[1:] {
+		if err := validateAmount(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	strategy, err := LookupStrategy(opts.StrategyName())
+	if err != nil {
+		return nil, err
+	}
+
+	budget, cancel := withBudget(ctx, opts.Budget)
+	defer cancel()
+
+	packings, done, err := packBatch(budget, strategy, p, amounts, opts)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return nil, fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+	case budget.Err() == nil:
+		return nil, err
+	}
+
+	orders := make([]Order, len(amounts))
+	for i, amount := range amounts {
+		if i < done {
+			orders[i] = p.newOrder(amount, packings[i])
+			continue
+		}
+
+		var found Packing
+		if i < len(packings) {
+			found = packings[i]
+		}
+
+		order, err := p.budgetOrder(amount, strategy, opts, found)
+		if err != nil {
+			return nil, err
+		}
+		orders[i] = order
+	}
+
+	return orders, nil
+}
+
+// packBatch packs the amounts with the strategy until ctx is done. The first
+// done packings are finished, and when ctx stopped the search the rest hold
+// the best packing found so far, if any.
+func packBatch(ctx context.Context, strategy PackingStrategy, p Packages, amounts []int, opts SolveOptions) ([]Packing, int, error) {
+	if batcher, ok := strategy.(BatchPacker); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		packings, err := batcher.PackBatch(ctx, p, amounts, opts)
+		if err != nil {
+			return packings, 0, err
+		}
+		return packings, len(packings), nil
+	}
+
+	packings := make([]Packing, 0, len(amounts))
+	for i, amount := range amounts {
+		packing, err := packContext(ctx, strategy, p, amount, opts)
+		packings = append(packings, packing)
+		if err != nil {
+			return packings, i, err
+		}
+	}
+
+	return packings, len(packings), nil
+}
+
+// PackBatch packs all the amounts from one table that covers the windows of
+// every amount. Limited stock is checked for each amount on its own.
+func (s exactStrategy) PackBatch(ctx context.Context, p Packages, amounts []int, opts SolveOptions) ([]Packing, error) {
+	packings := make([]Packing, 0, len(amounts))
+	if opts.Stock != nil {
+		for _, amount := range amounts {
+			packing, err := s.PackContext(ctx, p, amount, opts)
+			packings = append(packings, packing)
+			if err != nil {
+				return packings, err
+			}
+		}
+		return packings, nil
+	}
+
+	w, err := newSharedWindow(ctx, p.Sizes, p.Prices, amounts, opts.Objective, maxTableSpan)
+	if err != nil {
+		if w == nil {
+			return nil, err
+		}
+
+		for _, amount := range amounts {
+			w.seek(amount)
+			packings = append(packings, w.partial(amount, opts.TieBreak))
+		}
+		return packings, err
+	}
+
+	if err := w.supports(opts.TieBreak); err != nil {
+		return nil, err
+	}
+
+	w.tie = opts.TieBreak
+	for _, amount := range amounts {
+		w.seek(amount)
+		packings = append(packings, newPackingFromCounts(amount, w.packages(w.best())))
+	}
+
+	return packings, nil
+}
+
+// PackBatch packs all the amounts as cheaply as possible from one table
+func (costStrategy) PackBatch(ctx context.Context, p Packages, amounts []int, opts SolveOptions) ([]Packing, error) {
+	opts.Objective = ObjectiveCost
+	return exactStrategy{}.PackBatch(ctx, p, amounts, opts)
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrInsufficientStock is returned when the stock cannot cover an order
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// ShortfallError reports how many items an order is short by when the
+// available stock cannot cover it
+type ShortfallError struct {
+	Amount    int
+	Available int
+}
+
+// Error implements the error interface
+func (e *ShortfallError) Error() string {
+	return fmt.Sprintf("%s: need %d items, only %d available (short by %d)",
+		ErrInsufficientStock, e.Amount, e.Available, e.Shortfall())
+}
+
+// Unwrap lets errors.Is match ErrInsufficientStock
+func (e *ShortfallError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+// Shortfall returns the number of items the stock is missing
+func (e *ShortfallError) Shortfall() int {
+	return e.Amount - e.Available
+}
+
+// validateStock checks that no stock level is negative
+func validateStock(stock map[int]int) error {
+	for size, available := range stock {
+		if available < 0 {
+			return fmt.Errorf("%w: %d packages of size %d", ErrInvalidStock, available, size)
+		}
+	}
+
+	return nil
+}
+
+// maxBoundedCells is the most totals times stock bundles a bounded packing
+// may track, one byte each
+const maxBoundedCells = 1 << 26
+
+// boundedItem is a bundle of packages of one size that is either taken
+// whole or not at all
+type boundedItem struct {
+	index int // into the sorted sizes
+	count int
+}
+
+// boundedPacking returns the best packages for amount under the objective
+// using at most stock[size] packages of each listed size. Sizes missing from
+// stock are unlimited. Each size's stock is split into bundles of 1, 2, 4, ...
+// packages so the table only needs one pass per bundle. It stops with the
+// context's error once the context is done.
+func boundedPacking(ctx context.Context, sizes, prices []int, stock map[int]int, amount int, objective Objective) (map[int]int, error) {
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return sizes[order[a]] < sizes[order[b]] })
+
+	sorted := make([]int, len(sizes))
+	sortedPrices := make([]int, len(sizes))
+	largest := 0
+	for i, index := range order {
+		sorted[i] = sizes[index]
+		if objective == ObjectiveCost {
+			sortedPrices[i] = prices[index]
+		}
+		largest = max(largest, sizes[index])
+	}
+
+	span := amount + largest - 1
+
+	available := 0
+	var items []boundedItem
+	for i, size := range sorted {
+		// More packages than this are never part of a useful packing
+		useful := span/size + 1
+
+		limit, limited := stock[size]
+		if !limited || limit > useful {
+			limit = useful
+		}
+		available += limit * size
+
+		for bundle := 1; limit > 0; bundle *= 2 {
+			count := min(bundle, limit)
+			items = append(items, boundedItem{index: i, count: count})
+			limit -= count
+		}
+	}
+
+	if available < amount {
+		return nil, &ShortfallError{Amount: amount, Available: available}
+	}
+
+	if span > maxTableSpan || span*len(items) > maxBoundedCells {
+		return nil, fmt.Errorf("%w: packing %d items from limited stock needs a table of %d totals",
+			ErrAmountTooLarge, amount, span)
+	}
+
+	counts := make([]int, span+1)
+	costs := make([]int, span+1)
+	for total := 1; total <= span; total++ {
+		counts[total] = -1
+	}
+
+	cells := 0
+	taken := make([][]bool, len(items))
+	for n, item := range items {
+		taken[n] = make([]bool, span+1)
+		weight := item.count * sorted[item.index]
+		price := item.count * sortedPrices[item.index]
+
+		for total := span; total >= weight; total-- {
+			if err := interrupted(ctx, &cells, 1); err != nil {
+				return nil, err
+			}
+
+			prev := total - weight
+			if counts[prev] < 0 {
+				continue
+			}
+
+			// Take the bundle on a tie too. Bundles come smallest size first,
+			// so rebuilding from the last one keeps the larger packs, as
+			// TieLargerPacks does without stock.
+			count, cost := counts[prev]+item.count, costs[prev]+price
+			if counts[total] < 0 || cost < costs[total] || (cost == costs[total] && count <= counts[total]) {
+				counts[total], costs[total] = count, cost
+				taken[n][total] = true
+			}
+		}
+	}
+
+	best := -1
+	for total := amount; total <= span; total++ {
+		if counts[total] < 0 {
+			continue
+		}
+
+		if objective != ObjectiveCost {
+			best = total
+			break
+		}
+
+		if best < 0 || costs[total] < costs[best] {
+			best = total
+		}
+	}
+
+	if best < 0 {
+		return nil, &ShortfallError{Amount: amount, Available: available}
+	}
+
+	result := make(map[int]int)
+	for n := len(items) - 1; n >= 0 && best > 0; n-- {
+		if !taken[n][best] {
+			continue
+		}
+
+		size := sorted[items[n].index]
+		result[size] += items[n].count
+		best -= items[n].count * size
+	}
+
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// maxCatalogueSpan is the most totals a compiled catalogue table may hold.
+// Size sets that need more are not compiled and are solved per query.
+const maxCatalogueSpan = 1 << 20
+
+// Catalogue is a set of package sizes compiled for repeated packing. The
+// solver table for each objective is built once, by the first query that
+// needs it, to cover the window of every amount up to MaxAmount, so a query
+// only searches at most one largest package's worth of totals. A Catalogue is
+// safe for concurrent use and its orders are identical to those of
+// Packages.Solve.
+type Catalogue struct {
+	packages Packages
+	compiled [2]compiledWindow // by objective, see objectiveIndex
+}
+
+// compiledWindow is the table for one objective, nil until it is built or if
+// it would be too large
+type compiledWindow struct {
+	mu     sync.Mutex
+	built  bool
+	window *packingWindow
+}
+
+// CompileCatalogue validates the packages. No table is built until a query
+// needs it.
+func CompileCatalogue(p Packages) (*Catalogue, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Catalogue{packages: p}, nil
+}
+
+// Packages returns the packages the catalogue was compiled from
+func (c *Catalogue) Packages() Packages {
+	return c.packages
+}
+
+// Solve packs amount like Packages.Solve. Options the compiled tables do not
+// cover, such as limited stock or other strategies, are solved from scratch.
+func (c *Catalogue) Solve(amount int, opts SolveOptions) (Order, error) {
+	return c.SolveContext(context.Background(), amount, opts)
+}
+
+// SolveContext packs amount like Packages.SolveContext, within opts.Budget.
+// A compiled query is too short to need the budget, but building a table
+// stops once the budget runs out or ctx is done, and the next query builds it
+// again. Running out of budget then gives the approximate order of
+// Packages.SolveContext.
+func (c *Catalogue) SolveContext(ctx context.Context, amount int, opts SolveOptions) (Order, error) {
+	if err := c.packages.ValidateOrder(amount, opts); err != nil {
+		return Order{}, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return Order{}, fmt.Errorf("%w: %v", ErrCancelled, err)
+	}
+
+	strategy, err := LookupStrategy(opts.StrategyName())
+	if err != nil {
+		return Order{}, err
+	}
+
+	budget, cancel := withBudget(ctx, opts.Budget)
+	defer cancel()
+
+	objective := opts.Objective
+	switch strategy.(type) {
+	case exactStrategy:
+	case costStrategy:
+		objective = ObjectiveCost
+	default:
+		return c.packages.solveWithin(ctx, budget, strategy, amount, opts)
+	}
+
+	compiled, err := c.window(budget, objective)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return Order{}, fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+	default:
+		return c.packages.budgetOrder(amount, strategy, opts, Packing{})
+	}
+
+	if compiled == nil || opts.Stock != nil || !compiled.covers(amount) || compiled.supports(opts.TieBreak) != nil {
+		return c.packages.solveWithin(ctx, budget, strategy, amount, opts)
+	}
+
+	// Seek a copy, so queries never share the window's position
+	w := *compiled
+	w.tie = opts.TieBreak
+	w.seek(amount)
+	return c.packages.newOrder(amount, newPackingFromCounts(amount, w.packages(w.best()))), nil
+}
+
+// Bytes returns the memory held by the tables built so far
+func (c *Catalogue) Bytes() int {
+	bytes := 0
+	for i := range c.compiled {
+		compiled := &c.compiled[i]
+		compiled.mu.Lock()
+		if compiled.window != nil {
+			bytes += compiled.window.bytes()
+		}
+		compiled.mu.Unlock()
+	}
+
+	return bytes
+}
+
+// window returns the compiled table for the objective, building it on first
+// use. It is nil if the table would be too large. Sizes too wide for a table
+// compile their residues instead, which only cover the larger amounts. The error is only ever that
+// of ctx, and leaves the table to be built by a later query.
+func (c *Catalogue) window(ctx context.Context, objective Objective) (*packingWindow, error) {
+	compiled := &c.compiled[objectiveIndex(objective)]
+	compiled.mu.Lock()
+	defer compiled.mu.Unlock()
+
+	if compiled.built {
+		return compiled.window, nil
+	}
+
+	// Every amount above the bound is reduced to a remainder below
+	// bound+pivot, so the largest window ends below bound+pivot+largest
+	r := newReduction(c.packages.Sizes, c.packages.Prices, objective)
+	widest := min(MaxAmount, r.bound+r.pivot-1)
+
+	w, err := newSharedWindow(ctx, c.packages.Sizes, c.packages.Prices, []int{widest}, objective, maxCatalogueSpan)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+
+	compiled.built = true
+	if err == nil {
+		compiled.window = w
+	}
+	return compiled.window, nil
+}
+
+// objectiveIndex returns the slot of the objective's compiled table
+func objectiveIndex(objective Objective) int {
+	if objective == ObjectiveCost {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrCancelled is returned when the caller's context is done before the
+	// order is packed
+	ErrCancelled = errors.New("packing cancelled")
+	// ErrBudgetExceeded is returned when the time budget runs out and there is
+	// no packing to fall back to
+	ErrBudgetExceeded = errors.New("packing time budget exceeded")
+)
+
+// ContextPacker is implemented by strategies that can stop searching once a
+// context is done. A search stopped by its context returns the best packing
+// it had found so far with the error, or an empty Packing if it found none.
+// Other strategies only have the context checked before Pack, as a packing
+// they finish is never cut short.
+type ContextPacker interface {
+	PackContext(ctx context.Context, p Packages, amount int, opts SolveOptions) (Packing, error)
+}
+
+// SolveContext packs amount like Solve, but stops once ctx is done. When
+// opts.Budget runs out first, the order is the best packing the search had
+// found so far, or the fill from the largest size if that is better, and is
+// marked Approximate, as it may ship more items, packages or cost than the
+// best packing. Orders with limited stock have nothing to fall back to and
+// fail with ErrBudgetExceeded.
+func (p Packages) SolveContext(ctx context.Context, amount int, opts SolveOptions) (Order, error) {
+	if err := p.ValidateOrder(amount, opts); err != nil {
+		return Order{}, err
+	}
+
+	strategy, err := LookupStrategy(opts.StrategyName())
+	if err != nil {
+		return Order{}, err
+	}
+
+	budget, cancel := withBudget(ctx, opts.Budget)
+	defer cancel()
+
+	return p.solveWithin(ctx, budget, strategy, amount, opts)
+}
+
+// solveWithin packs a validated order with the strategy until budget, which
+// is ctx or derived from it, is done. Running out of budget falls back to
+// budgetOrder, while ctx being done fails with ErrCancelled.
+func (p Packages) solveWithin(ctx, budget context.Context, strategy PackingStrategy, amount int, opts SolveOptions) (Order, error) {
+	packing, err := packContext(budget, strategy, p, amount, opts)
+	switch {
+	case err == nil:
+		return p.newOrder(amount, packing), nil
+	case ctx.Err() != nil:
+		return Order{}, fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+	case budget.Err() == nil:
+		return Order{}, err
+	}
+
+	return p.budgetOrder(amount, strategy, opts, packing)
+}
+
+// withBudget returns a context that is done once the budget runs out, or ctx
+// itself if there is no budget
+func withBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+// budgetOrder returns the approximate order for amount once the budget ran
+// out: the packing the strategy found, unless filling from the largest size
+// is better or it found none
+func (p Packages) budgetOrder(amount int, strategy PackingStrategy, opts SolveOptions, found Packing) (Order, error) {
+	if opts.Stock != nil {
+		return Order{}, fmt.Errorf("%w: no packing within the stock after %v", ErrBudgetExceeded, opts.Budget)
+	}
+
+	objective := opts.Objective
+	if _, ok := strategy.(costStrategy); ok {
+		objective = ObjectiveCost
+	}
+
+	packing := fillPacking(p.Sizes, amount)
+	if len(found.Packs) > 0 && p.betterPacking(found, packing, objective) {
+		packing = found
+	}
+
+	order := p.newOrder(amount, packing)
+	order.Approximate = true
+	return order, nil
+}
+
+// betterPacking reports whether a is a better packing than b under the
+// objective: a lower price when costs are minimised, then fewer items, then
+// fewer packages
+func (p Packages) betterPacking(a, b Packing, objective Objective) bool {
+	if objective == ObjectiveCost {
+		if costA, costB := p.costOf(a.Counts()), p.costOf(b.Counts()); costA != costB {
+			return costA < costB
+		}
+	}
+
+	if a.Items != b.Items {
+		return a.Items < b.Items
+	}
+	return a.Packages < b.Packages
+}
+
+// packContext packs amount with the strategy, passing ctx on if the strategy
+// can stop early
+func packContext(ctx context.Context, strategy PackingStrategy, p Packages, amount int, opts SolveOptions) (Packing, error) {
+	if err := ctx.Err(); err != nil {
+		return Packing{}, err
+	}
+
+	if packer, ok := strategy.(ContextPacker); ok {
+		return packer.PackContext(ctx, p, amount, opts)
+	}
+
+	return strategy.Pack(p, amount, opts)
+}
+
+// fillPacking fills amount from the largest size down and tops up with the
+// smallest size that covers the rest. It needs no table, so it always covers
+// the order at once, but may ship more than the best packing.
+func fillPacking(sizes []int, amount int) Packing {
+	sorted := append([]int(nil), sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	counts := make(map[int]int)
+	remaining := amount
+	for _, size := range sorted {
+		counts[size] += remaining / size
+		remaining %= size
+	}
+
+	// What is left is smaller than every size, so the smallest covers it
+	if remaining > 0 {
+		counts[sorted[len(sorted)-1]]++
+	}
+
+	return newPackingFromCounts(amount, counts)
+}
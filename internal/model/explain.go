@@ -0,0 +1,212 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxExplainCandidates is the most candidate totals an Explanation lists
+	MaxExplainCandidates = 50
+	// maxRunnersUp is the most runner-up packings an Explanation lists
+	maxRunnersUp = 3
+)
+
+// Candidate is one packing the solver considered for an order
+type Candidate struct {
+	Items     int         `json:"items"`
+	Overshoot int         `json:"overshoot"`
+	Packages  int         `json:"packages"`
+	Cost      int         `json:"cost,omitempty"`
+	Packs     []PackCount `json:"packs"`
+}
+
+// Explanation describes why a packing was chosen for an order
+type Explanation struct {
+	Strategy string `json:"strategy"`
+	// Rule is the order in which candidates are compared
+	Rule string `json:"rule"`
+	// Considered is the number of reachable totals that were compared, of
+	// which Candidates lists the smallest
+	Considered int         `json:"considered"`
+	Candidates []Candidate `json:"candidates"`
+	Chosen     Candidate   `json:"chosen"`
+	// RunnersUp are the next best candidates, best first
+	RunnersUp []Candidate `json:"runnersUp"`
+	// DecidedBy names what separated the chosen packing from the first
+	// runner-up: "cost", "items" or "packages"
+	DecidedBy string `json:"decidedBy,omitempty"`
+}
+
+// Explainer is implemented by strategies that can show the candidates they
+// compared. Other strategies are explained by their packing alone. Like
+// PackContext, Explain stops once ctx is done and returns the best packing
+// it had found so far with the error.
+type Explainer interface {
+	Explain(ctx context.Context, p Packages, amount int, opts SolveOptions) (Packing, Explanation, error)
+}
+
+// Explain solves the order like Solve and also returns why its packing was
+// chosen
+func (p Packages) Explain(amount int, opts SolveOptions) (Order, Explanation, error) {
+	return p.ExplainContext(context.Background(), amount, opts)
+}
+
+// ExplainContext explains the order like Explain, but stops once ctx is done.
+// When opts.Budget runs out first, the order is the approximate one
+// SolveContext would return, explained by its packing alone.
+func (p Packages) ExplainContext(ctx context.Context, amount int, opts SolveOptions) (Order, Explanation, error) {
+	if err := p.ValidateOrder(amount, opts); err != nil {
+		return Order{}, Explanation{}, err
+	}
+
+	strategy, err := LookupStrategy(opts.StrategyName())
+	if err != nil {
+		return Order{}, Explanation{}, err
+	}
+
+	budget, cancel := withBudget(ctx, opts.Budget)
+	defer cancel()
+
+	var packing Packing
+	var explanation Explanation
+	if explainer, ok := strategy.(Explainer); ok {
+		packing, explanation, err = explainer.Explain(budget, p, amount, opts)
+	} else {
+		packing, err = packContext(budget, strategy, p, amount, opts)
+		explanation = p.explainPacking(amount, packing, fmt.Sprintf("chosen by the %s strategy", strategy.Name()))
+	}
+
+	switch {
+	case err == nil:
+		explanation.Strategy = strategy.Name()
+		return p.newOrder(amount, packing), explanation, nil
+	case ctx.Err() != nil:
+		return Order{}, Explanation{}, fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+	case budget.Err() == nil:
+		return Order{}, Explanation{}, err
+	}
+
+	order, err := p.budgetOrder(amount, strategy, opts, packing)
+	if err != nil {
+		return Order{}, Explanation{}, err
+	}
+
+	explanation = p.explainPacking(amount, order.Packing, "the best packing found within the time budget")
+	explanation.Strategy = strategy.Name()
+	return order, explanation, nil
+}
+
+// explainPacking explains a packing that was the only candidate considered
+func (p Packages) explainPacking(amount int, packing Packing, rule string) Explanation {
+	chosen := Candidate{
+		Items:     packing.Items,
+		Overshoot: packing.Overshoot,
+		Packages:  packing.Packages,
+		Cost:      p.costOf(packing.Counts()),
+		Packs:     packing.Packs,
+	}
+
+	return Explanation{
+		Rule:       rule,
+		Considered: 1,
+		Candidates: []Candidate{chosen},
+		Chosen:     chosen,
+		RunnersUp:  []Candidate{},
+	}
+}
+
+func (exactStrategy) Explain(ctx context.Context, p Packages, amount int, opts SolveOptions) (Packing, Explanation, error) {
+	if opts.Stock != nil {
+		packing, err := exactStrategy{}.PackContext(ctx, p, amount, opts)
+		if err != nil {
+			return Packing{}, Explanation{}, err
+		}
+
+		return packing, p.explainPacking(amount, packing, objectiveRule(opts.Objective)+", within the stock"), nil
+	}
+
+	w, err := newPackingWindow(ctx, p.Sizes, p.Prices, amount, opts.Objective)
+	if err != nil {
+		return w.partial(amount, opts.TieBreak), Explanation{}, err
+	}
+	if err := w.supports(opts.TieBreak); err != nil {
+		return Packing{}, Explanation{}, err
+	}
+	w.tie = opts.TieBreak
+
+	rule := objectiveRule(opts.Objective)
+	if opts.TieBreak != "" {
+		rule += ", with ties broken by " + opts.TieBreak.rule()
+	}
+
+	var totals []int
+	for total := w.from; total <= w.to; total++ {
+		if w.reachable(total) {
+			totals = append(totals, total)
+		}
+	}
+
+	explanation := Explanation{
+		Rule:       rule,
+		Considered: len(totals),
+		Candidates: make([]Candidate, 0, min(len(totals), MaxExplainCandidates)),
+	}
+	for _, total := range totals[:min(len(totals), MaxExplainCandidates)] {
+		explanation.Candidates = append(explanation.Candidates, w.candidate(p, amount, total))
+	}
+
+	sort.SliceStable(totals, func(i, j int) bool { return w.better(totals[i], totals[j]) })
+	explanation.Chosen = w.candidate(p, amount, totals[0])
+	explanation.RunnersUp = make([]Candidate, 0, maxRunnersUp)
+	for _, total := range totals[1:min(len(totals), maxRunnersUp+1)] {
+		explanation.RunnersUp = append(explanation.RunnersUp, w.candidate(p, amount, total))
+	}
+
+	if len(explanation.RunnersUp) > 0 {
+		explanation.DecidedBy = decidedBy(opts.Objective, explanation.Chosen, explanation.RunnersUp[0])
+	}
+
+	return newPackingFromCounts(amount, w.packages(totals[0])), explanation, nil
+}
+
+func (costStrategy) Explain(ctx context.Context, p Packages, amount int, opts SolveOptions) (Packing, Explanation, error) {
+	opts.Objective = ObjectiveCost
+	return exactStrategy{}.Explain(ctx, p, amount, opts)
+}
+
+// candidate describes the packing of a table total in the window. The cost
+// is priced from the packages, as the table only keeps costs when they are
+// minimised.
+func (w *packingWindow) candidate(p Packages, amount, total int) Candidate {
+	packing := newPackingFromCounts(amount, w.packages(total))
+	return Candidate{
+		Items:     packing.Items,
+		Overshoot: packing.Overshoot,
+		Packages:  packing.Packages,
+		Cost:      p.costOf(packing.Counts()),
+		Packs:     packing.Packs,
+	}
+}
+
+// objectiveRule describes how candidates are compared under the objective
+func objectiveRule(objective Objective) string {
+	if objective == ObjectiveCost {
+		return "lowest cost, then fewest items shipped, then fewest packages"
+	}
+	return "fewest items shipped, then fewest packages"
+}
+
+// decidedBy names the first criterion of the objective on which chosen beats
+// the runner-up
+func decidedBy(objective Objective, chosen, runnerUp Candidate) string {
+	switch {
+	case objective == ObjectiveCost && chosen.Cost != runnerUp.Cost:
+		return "cost"
+	case chosen.Items != runnerUp.Items:
+		return "items"
+	default:
+		return "packages"
+	}
+}
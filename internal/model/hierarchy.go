@@ -0,0 +1,121 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// PackLevel is the name of the innermost level of a packing tree
+const PackLevel = "pack"
+
+// ErrInvalidHierarchy is returned when a packaging hierarchy cannot be used
+var ErrInvalidHierarchy = errors.New("invalid packaging hierarchy")
+
+// Level is one level of packaging, such as a carton or a pallet, that holds a
+// fixed number of units of the level below it
+type Level struct {
+	Name  string `json:"name"`
+	Holds int    `json:"holds"`
+}
+
+// Hierarchy nests packs in levels of packaging. Levels run from the
+// innermost outwards, so Levels[0] holds packs and every other level holds
+// units of the level before it. When PackSize is set only packs of that size
+// are nested and the others are left loose; otherwise packs of every size are
+// nested, each size in units of its own.
+type Hierarchy struct {
+	PackSize int     `json:"packSize,omitempty"`
+	Levels   []Level `json:"levels"`
+}
+
+// Unit is a node of a packing tree: Count units of one level, each holding
+// Items items made up of Contents
+type Unit struct {
+	Level    string `json:"level"`
+	Items    int    `json:"items"`
+	Count    int    `json:"count"`
+	Contents []Unit `json:"contents,omitempty"`
+}
+
+// validate checks that the hierarchy nests one of the package sizes, or all
+// of them, and that no unit holds more than MaxAmount items
+func (h Hierarchy) validate(sizes []int) error {
+	largest := h.PackSize
+	if h.PackSize == 0 {
+		for _, size := range sizes {
+			largest = max(largest, size)
+		}
+	} else if !slices.Contains(sizes, h.PackSize) {
+		return fmt.Errorf("%w: pack size %d is not a package size", ErrInvalidHierarchy, h.PackSize)
+	}
+
+	if len(h.Levels) == 0 {
+		return fmt.Errorf("%w: no levels", ErrInvalidHierarchy)
+	}
+
+	seen := map[string]bool{PackLevel: true}
+	items := largest
+	for _, level := range h.Levels {
+		if level.Name == "" || seen[level.Name] {
+			return fmt.Errorf("%w: level name %q is missing or used twice", ErrInvalidHierarchy, level.Name)
+		}
+		seen[level.Name] = true
+
+		if level.Holds < 1 || level.Holds > MaxAmount/items {
+			return fmt.Errorf("%w: %s holds %d", ErrInvalidHierarchy, level.Name, level.Holds)
+		}
+		items *= level.Holds
+	}
+
+	return nil
+}
+
+// Nest rolls the packs of each nested size up into the levels, largest size
+// first and filling the outermost level first, and returns the packing as a
+// tree. Packs left over, and those of sizes that are not nested, are listed
+// loose after the units of their size.
+func (h Hierarchy) Nest(packing Packing) []Unit {
+	var tree []Unit
+	for _, pack := range packing.Packs {
+		if h.PackSize != 0 && pack.Size != h.PackSize {
+			if pack.Count > 0 {
+				tree = append(tree, Unit{Level: PackLevel, Items: pack.Size, Count: pack.Count})
+			}
+			continue
+		}
+		tree = append(tree, h.nestPacks(pack)...)
+	}
+
+	return tree
+}
+
+// nestPacks rolls the packs of one size up into the levels, outermost first,
+// with the packs left over loose at the end
+func (h Hierarchy) nestPacks(pack PackCount) []Unit {
+	// contents[i] is one full unit of level i-1, with contents[0] a single pack
+	contents := []Unit{{Level: PackLevel, Items: pack.Size, Count: 1}}
+	for _, level := range h.Levels {
+		inner := contents[len(contents)-1]
+		inner.Count = level.Holds
+		contents = append(contents, Unit{Level: level.Name, Items: inner.Items * level.Holds, Contents: []Unit{inner}})
+	}
+
+	var units []Unit
+	remaining := pack.Count
+	for i := len(contents) - 1; i > 0; i-- {
+		packs := contents[i].Items / pack.Size
+		if count := remaining / packs; count > 0 {
+			unit := contents[i]
+			unit.Count = count
+			units = append(units, unit)
+			remaining -= count * packs
+		}
+	}
+
+	if remaining > 0 {
+		units = append(units, Unit{Level: PackLevel, Items: pack.Size, Count: remaining})
+	}
+
+	return units
+}
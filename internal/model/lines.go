@@ -0,0 +1,94 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoLines is returned when a multi-line order has no line items
+	ErrNoLines = errors.New("order has no line items")
+	// ErrMissingSKU is returned when a line item has no SKU
+	ErrMissingSKU = errors.New("line item has no SKU")
+	// ErrDuplicateSKU is returned when a SKU appears on more than one line
+	ErrDuplicateSKU = errors.New("duplicate SKU")
+)
+
+// LineItem is one product of a multi-line order with its own package sizes
+type LineItem struct {
+	SKU      string   `json:"sku"`
+	Amount   int      `json:"amount"`
+	Packages Packages `json:"packages"`
+}
+
+// Line is the packing of one line item
+type Line struct {
+	SKU      string   `json:"sku"`
+	Packages Packages `json:"packages"`
+	Order    Order    `json:"order"`
+}
+
+// MultiLineOrder is an order made of several line items and its totals
+type MultiLineOrder struct {
+	Lines         []Line `json:"lines"`
+	TotalAmount   int    `json:"totalAmount"`
+	TotalItems    int    `json:"totalItems"`
+	TotalPackages int    `json:"totalPackages"`
+	TotalCost     int    `json:"totalCost,omitempty"`
+}
+
+// SolveLines packs every line item with its own package sizes. The order is
+// solved as a whole: if any line cannot be packed, no result is returned.
+func SolveLines(items []LineItem, opts SolveOptions) (MultiLineOrder, error) {
+	return SolveLinesContext(context.Background(), items, opts)
+}
+
+// SolveLinesContext packs every line item like SolveLines, but stops once ctx
+// is done. opts.Budget limits the search of each line, as for SolveContext.
+func SolveLinesContext(ctx context.Context, items []LineItem, opts SolveOptions) (MultiLineOrder, error) {
+	if err := ValidateLines(items, opts); err != nil {
+		return MultiLineOrder{}, err
+	}
+
+	result := MultiLineOrder{Lines: make([]Line, 0, len(items))}
+	for i, item := range items {
+		order, err := item.Packages.SolveContext(ctx, item.Amount, opts)
+		if err != nil {
+			return MultiLineOrder{}, fmt.Errorf("line %d (%s): %w", i+1, item.SKU, err)
+		}
+
+		result.Lines = append(result.Lines, Line{SKU: item.SKU, Packages: item.Packages, Order: order})
+		result.TotalAmount += order.Amount
+		result.TotalItems += order.Packing.Items
+		result.TotalPackages += order.Packing.Packages
+		result.TotalCost += order.Cost
+	}
+
+	return result, nil
+}
+
+// ValidateLines checks every line item without solving any of them
+func ValidateLines(items []LineItem, opts SolveOptions) error {
+	if len(items) == 0 {
+		return ErrNoLines
+	}
+
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		if item.SKU == "" {
+			return fmt.Errorf("line %d: %w", i+1, ErrMissingSKU)
+		}
+
+		if seen[item.SKU] {
+			return fmt.Errorf("line %d: %w: %s", i+1, ErrDuplicateSKU, item.SKU)
+		}
+		seen[item.SKU] = true
+
+		if err := item.Packages.ValidateOrder(item.Amount, opts); err != nil {
+			return fmt.Errorf("line %d (%s): %w", i+1, item.SKU, err)
+		}
+	}
+
+	return nil
+}
@@ -1,72 +1,103 @@
 package model
 
-import "sort"
+import "fmt"
 
 type Item struct {
 	Name string `json:"name"`
 }
 
-// Order represents a customer's order
+// MaxResultPackages is the most packages an Order lists one by one in Result.
+// Larger orders are only described by their Packing.
+const MaxResultPackages = 100_000
+
+// Order represents a customer's order. Result lists every package, while
+// Packing groups the same packages by size and Tree nests them in the
+// packaging hierarchy, if there is one. Quantities gives the amounts in the
+// unit of measure of the packages, if they have one. Approximate is set when
+// the solver ran out of time and the packing may not be the best.
 type Order struct {
-	Amount int   `json:"amount"`
-	Result []int `json:"result"`
+	Amount      int         `json:"amount"`
+	Result      []int       `json:"result"`
+	Packing     Packing     `json:"packing"`
+	Tree        []Unit      `json:"tree,omitempty"`
+	Cost        int         `json:"cost,omitempty"`
+	Quantities  *Quantities `json:"quantities,omitempty"`
+	Approximate bool        `json:"approximate,omitempty"`
 }
 
-// Packages represents all available package sizes
+// Packages represents all available package sizes and, optionally, the price
+// of each size in minor currency units (Prices[i] is the price of Sizes[i])
+// and the weight of each size in grams, the hierarchy packs are nested in and
+// the scale sizes and amounts are counted on when they are sold by weight or
+// volume
 type Packages struct {
-	Sizes []int `json:"sizes"`
+	Sizes     []int      `json:"sizes"`
+	Prices    []int      `json:"prices,omitempty"`
+	Weights   []int      `json:"weights,omitempty"`
+	Hierarchy *Hierarchy `json:"hierarchy,omitempty"`
+	Scale     *Scale     `json:"scale,omitempty"`
 }
 
-// Function to find the least amount of packages
-func (p *Packages) FindPackages(order *Order, packageSizes *Packages) {
-	sort.Sort(sort.Reverse(sort.IntSlice(packageSizes.Sizes))) // Sort in descending order for easier comparison
+// Cost returns the total price of the given packages. Sizes without a price
+// are free.
+func (p Packages) Cost(packages []int) int {
+	return p.costOf(NewPacking(0, packages).Counts())
+}
 
-	var result []int
-	remainingOrder := order.Amount
+// costOf returns the total price of the given number of packages of each size
+func (p Packages) costOf(counts map[int]int) int {
+	if len(p.Prices) == 0 {
+		return 0
+	}
 
-	for _, size := range packageSizes.Sizes {
-		for remainingOrder >= size {
-			remainingOrder -= size
-			result = append(result, size)
-		}
+	total := 0
+	for i, size := range p.Sizes {
+		total += counts[size] * p.Prices[i]
 	}
 
-	// sort in ascending order of the package sizes
-	sort.Sort(sort.IntSlice(packageSizes.Sizes))
+	return total
+}
 
-	// If there's still some order left that needs to be fulfilled
-	if remainingOrder > 0 {
-		for _, size := range packageSizes.Sizes {
-			if remainingOrder <= size {
-				result = append(result, size)
-				break
-			}
-		}
+// FindPackages finds the packages that ship the fewest items for the order
+// and, among those, the fewest packages. It only writes order.Result; use
+// Packages.Pack when no Order is needed.
+func (p *Packages) FindPackages(order *Order, packageSizes *Packages) error {
+	result, err := packageSizes.Pack(order.Amount)
+	if err != nil {
+		return err
 	}
 
-	// Optimize the package sizes at the end
-	order.Result = p.OptimizePackages(result, packageSizes.Sizes)
+	order.Result = result
+	return nil
 }
 
-func (p *Packages) OptimizePackages(packages, packageSizes []int) []int {
+// OptimizePackages returns the fewest packages that add up to the same total
+// as the given packages
+func (p *Packages) OptimizePackages(packages, packageSizes []int) ([]int, error) {
+	if err := (Packages{Sizes: packageSizes}).Validate(); err != nil {
+		return nil, err
+	}
+
 	totalSize := 0
 	for _, size := range packages {
+		if size <= 0 {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidSize, size)
+		}
 		totalSize += size
 	}
 
-	combinations := make([][]int, totalSize+1)
-	combinations[0] = []int{}
-
-	for _, size := range packageSizes {
-		for i := 0; i <= totalSize-size; i++ {
-			if combinations[i] != nil && (combinations[i+size] == nil || len(combinations[i])+1 < len(combinations[i+size])) {
-				combination := make([]int, len(combinations[i]), len(combinations[i])+1)
-				copy(combination, combinations[i])
-				combination = append(combination, size)
-				combinations[i+size] = combination
-			}
-		}
+	if err := validateAmount(totalSize); err != nil {
+		return nil, err
+	}
+
+	counts, ok, err := exactPacking(packageSizes, totalSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("total %d cannot be made from sizes %v", totalSize, packageSizes)
 	}
 
-	return combinations[totalSize]
+	return newPackingFromCounts(totalSize, counts).Flatten(), nil
 }
@@ -1,8 +1,17 @@
 package model
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestFindPackages(t *testing.T) {
@@ -36,10 +45,2204 @@ func TestFindPackages(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			packageSizes.FindPackages(tt.order, packageSizes)
+			if err := packageSizes.FindPackages(tt.order, packageSizes); err != nil {
+				t.Fatalf("FindPackages() error = %v", err)
+			}
 			if !reflect.DeepEqual(tt.order.Result, tt.want) {
 				t.Errorf("FindPackages() = %v, want %v", tt.order.Result, tt.want)
 			}
 		})
 	}
 }
+
+func TestFindPackagesSkippedTotals(t *testing.T) {
+	tests := []struct {
+		name     string
+		sizes    []int
+		amount   int
+		want     []int
+		wantSize int
+	}{
+		{
+			name:     "Smaller overshoot than greedy",
+			sizes:    []int{4, 7},
+			amount:   8,
+			want:     []int{4, 4},
+			wantSize: 8,
+		},
+		{
+			name:     "Exact total missed by greedy",
+			sizes:    []int{23, 31, 53},
+			amount:   500000,
+			wantSize: 500000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packageSizes := &Packages{Sizes: tt.sizes}
+			order := &Order{Amount: tt.amount}
+			if err := packageSizes.FindPackages(order, packageSizes); err != nil {
+				t.Fatalf("FindPackages() error = %v", err)
+			}
+
+			if tt.want != nil && !reflect.DeepEqual(order.Result, tt.want) {
+				t.Errorf("FindPackages() = %v, want %v", order.Result, tt.want)
+			}
+
+			if total := sum(order.Result); total != tt.wantSize {
+				t.Errorf("FindPackages() ships %d items, want %d", total, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestFindPackagesMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 40)
+		amount := 1 + rng.Intn(150)
+
+		counts, err := bestPacking(sizes, nil, amount, ObjectivePackages)
+		if err != nil {
+			t.Fatalf("bestPacking(%v, %d) error = %v", sizes, amount, err)
+		}
+		result := flatten(counts)
+
+		wantTotal, wantCount := bruteForcePacking(sizes, amount)
+		if total := sum(result); total != wantTotal || len(result) != wantCount {
+			t.Fatalf("bestPacking(%v, %d) = %v (%d items, %d packages), want %d items in %d packages",
+				sizes, amount, result, total, len(result), wantTotal, wantCount)
+		}
+
+		for _, size := range result {
+			if !contains(sizes, size) {
+				t.Fatalf("bestPacking(%v, %d) = %v uses unknown size %d", sizes, amount, result, size)
+			}
+		}
+	}
+}
+
+func TestPackValidation(t *testing.T) {
+	tooMany := make([]int, MaxSizes+1)
+	for i := range tooMany {
+		tooMany[i] = i + 1
+	}
+
+	tests := []struct {
+		name    string
+		sizes   []int
+		amount  int
+		wantErr error
+	}{
+		{name: "Empty sizes", sizes: nil, amount: 10, wantErr: ErrEmptySizes},
+		{name: "Zero size", sizes: []int{0, 5}, amount: 10, wantErr: ErrInvalidSize},
+		{name: "Negative size", sizes: []int{-5}, amount: 10, wantErr: ErrInvalidSize},
+		{name: "Size too large", sizes: []int{MaxSize + 1}, amount: 10, wantErr: ErrInvalidSize},
+		{name: "Duplicate size", sizes: []int{5, 10, 5}, amount: 10, wantErr: ErrDuplicateSize},
+		{name: "Zero amount", sizes: []int{5}, amount: 0, wantErr: ErrNonPositiveAmount},
+		{name: "Negative amount", sizes: []int{5}, amount: -1, wantErr: ErrNonPositiveAmount},
+		{name: "Amount too large", sizes: []int{5}, amount: MaxAmount + 1, wantErr: ErrAmountTooLarge},
+		{name: "Too many sizes", sizes: tooMany, amount: 10, wantErr: ErrTooManySizes},
+		{name: "Valid", sizes: []int{5, 10}, amount: 12, wantErr: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packageSizes := &Packages{Sizes: tt.sizes}
+			_, err := packageSizes.Pack(tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Pack() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOptimizePackages(t *testing.T) {
+	packageSizes := &Packages{Sizes: []int{250, 500, 1000, 2000, 5000}}
+
+	got, err := packageSizes.OptimizePackages([]int{250, 250, 500, 5000}, packageSizes.Sizes)
+	if err != nil {
+		t.Fatalf("OptimizePackages() error = %v", err)
+	}
+
+	if want := []int{1000, 5000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OptimizePackages() = %v, want %v", got, want)
+	}
+
+	if _, err := packageSizes.OptimizePackages([]int{250}, []int{0}); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("OptimizePackages() error = %v, want %v", err, ErrInvalidSize)
+	}
+}
+
+func TestPackDoesNotModifySizes(t *testing.T) {
+	sizes := []int{1000, 250, 5000, 500, 2000}
+	packageSizes := Packages{Sizes: sizes}
+
+	if _, err := packageSizes.Pack(12001); err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+
+	if want := []int{1000, 250, 5000, 500, 2000}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Pack() reordered sizes to %v, want %v", sizes, want)
+	}
+}
+
+func TestSolveCost(t *testing.T) {
+	packageSizes := Packages{
+		Sizes:  []int{250, 500, 1000, 2000, 5000},
+		Prices: []int{300, 550, 800, 1500, 3000},
+	}
+
+	tests := []struct {
+		name      string
+		amount    int
+		objective Objective
+		want      []int
+		wantCost  int
+	}{
+		{
+			name:      "Fewest packages reports cost",
+			amount:    501,
+			objective: ObjectivePackages,
+			want:      []int{250, 500},
+			wantCost:  850,
+		},
+		{
+			name:      "Cheaper larger pack",
+			amount:    501,
+			objective: ObjectiveCost,
+			want:      []int{1000},
+			wantCost:  800,
+		},
+		{
+			name:      "Bulk discount",
+			amount:    4001,
+			objective: ObjectiveCost,
+			want:      []int{5000},
+			wantCost:  3000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := packageSizes.Solve(tt.amount, SolveOptions{Objective: tt.objective})
+			if err != nil {
+				t.Fatalf("Solve() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(order.Result, tt.want) || order.Cost != tt.wantCost {
+				t.Errorf("Solve() = %v costing %d, want %v costing %d", order.Result, order.Cost, tt.want, tt.wantCost)
+			}
+		})
+	}
+}
+
+func TestSolveCostMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	for i := 0; i < 500; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 40)
+		prices := make([]int, len(sizes))
+		for j := range prices {
+			prices[j] = rng.Intn(60)
+		}
+		amount := 1 + rng.Intn(150)
+
+		counts, err := bestPacking(sizes, prices, amount, ObjectiveCost)
+		if err != nil {
+			t.Fatalf("bestPacking(%v, %v, %d) error = %v", sizes, prices, amount, err)
+		}
+		result := flatten(counts)
+		cost := Packages{Sizes: sizes, Prices: prices}.Cost(result)
+
+		wantCost, wantTotal, wantCount := bruteForceCheapest(sizes, prices, amount)
+		if total := sum(result); cost != wantCost || total != wantTotal || len(result) != wantCount {
+			t.Fatalf("bestPacking(%v, %v, %d) = %v (cost %d, %d items, %d packages), want cost %d, %d items, %d packages",
+				sizes, prices, amount, result, cost, total, len(result), wantCost, wantTotal, wantCount)
+		}
+	}
+}
+
+func TestSolveObjectiveValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages Packages
+		opts     SolveOptions
+		wantErr  error
+	}{
+		{
+			name:     "Cost without prices",
+			packages: Packages{Sizes: []int{5, 10}},
+			opts:     SolveOptions{Objective: ObjectiveCost},
+			wantErr:  ErrMissingPrices,
+		},
+		{
+			name:     "Unknown objective",
+			packages: Packages{Sizes: []int{5, 10}},
+			opts:     SolveOptions{Objective: "fastest"},
+			wantErr:  ErrUnknownObjective,
+		},
+		{
+			name:     "Prices do not match sizes",
+			packages: Packages{Sizes: []int{5, 10}, Prices: []int{1}},
+			wantErr:  ErrInvalidPrice,
+		},
+		{
+			name:     "Negative price",
+			packages: Packages{Sizes: []int{5, 10}, Prices: []int{1, -1}},
+			wantErr:  ErrInvalidPrice,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.packages.Solve(10, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Solve() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSolveStock(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{250, 500, 1000, 2000, 5000}}
+
+	tests := []struct {
+		name    string
+		amount  int
+		stock   map[int]int
+		want    []int
+		wantErr error
+	}{
+		{
+			name:   "Plenty of stock",
+			amount: 12001,
+			stock:  map[int]int{250: 10, 500: 10, 1000: 10, 2000: 10, 5000: 10},
+			want:   []int{250, 2000, 5000, 5000},
+		},
+		{
+			name:   "Out of 5000-packs",
+			amount: 12001,
+			stock:  map[int]int{5000: 0},
+			want:   []int{250, 2000, 2000, 2000, 2000, 2000, 2000},
+		},
+		{
+			name:   "One 5000-pack left",
+			amount: 12001,
+			stock:  map[int]int{5000: 1},
+			want:   []int{250, 1000, 2000, 2000, 2000, 5000},
+		},
+		{
+			name:    "Shortfall",
+			amount:  12001,
+			stock:   map[int]int{250: 1, 500: 1, 1000: 1, 2000: 1, 5000: 1},
+			wantErr: ErrInsufficientStock,
+		},
+		{
+			name:    "Negative stock",
+			amount:  10,
+			stock:   map[int]int{250: -1},
+			wantErr: ErrInvalidStock,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := packageSizes.Solve(tt.amount, SolveOptions{Stock: tt.stock})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Solve() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && !reflect.DeepEqual(order.Result, tt.want) {
+				t.Errorf("Solve() = %v, want %v", order.Result, tt.want)
+			}
+		})
+	}
+}
+
+func TestSolveStockShortfall(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{250, 500}}
+
+	_, err := packageSizes.Solve(1000, SolveOptions{Stock: map[int]int{250: 1, 500: 1}})
+
+	var shortfall *ShortfallError
+	if !errors.As(err, &shortfall) {
+		t.Fatalf("Solve() error = %v, want a ShortfallError", err)
+	}
+
+	if shortfall.Shortfall() != 250 {
+		t.Errorf("Shortfall() = %d, want 250", shortfall.Shortfall())
+	}
+}
+
+func TestSolveStockMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+
+	for i := 0; i < 500; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 40)
+		limits := make([]int, len(sizes))
+		stock := make(map[int]int, len(sizes))
+		for j, size := range sizes {
+			limits[j] = rng.Intn(6)
+			stock[size] = limits[j]
+		}
+		amount := 1 + rng.Intn(150)
+
+		wantTotal, wantCount := -1, -1
+		var want map[int]int
+		eachCover(sizes, limits, amount, func(counts []int) {
+			total, count := 0, 0
+			packing := make(map[int]int)
+			for j, n := range counts {
+				total += n * sizes[j]
+				count += n
+				if n > 0 {
+					packing[sizes[j]] = n
+				}
+			}
+
+			if total < amount {
+				return
+			}
+			if wantTotal < 0 || total < wantTotal || (total == wantTotal && count < wantCount) ||
+				(total == wantTotal && count == wantCount && largerPacks(packing, want)) {
+				wantTotal, wantCount, want = total, count, packing
+			}
+		})
+
+		counts, err := boundedPacking(context.Background(), sizes, nil, stock, amount, ObjectivePackages)
+		result := flatten(counts)
+		if wantTotal < 0 {
+			if !errors.Is(err, ErrInsufficientStock) {
+				t.Fatalf("boundedPacking(%v, %v, %d) error = %v, want %v", sizes, stock, amount, err, ErrInsufficientStock)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("boundedPacking(%v, %v, %d) error = %v", sizes, stock, amount, err)
+		}
+
+		used := make(map[int]int)
+		for _, size := range result {
+			used[size]++
+		}
+		for size, n := range used {
+			if n > stock[size] {
+				t.Fatalf("boundedPacking(%v, %v, %d) = %v uses %d of size %d", sizes, stock, amount, result, n, size)
+			}
+		}
+
+		if total := sum(result); total != wantTotal || len(result) != wantCount {
+			t.Fatalf("boundedPacking(%v, %v, %d) = %v (%d items, %d packages), want %d items in %d packages",
+				sizes, stock, amount, result, total, len(result), wantTotal, wantCount)
+		}
+
+		if !reflect.DeepEqual(counts, want) {
+			t.Fatalf("boundedPacking(%v, %v, %d) = %v, want the larger packs %v", sizes, stock, amount, result, flatten(want))
+		}
+	}
+}
+
+func TestSolvePlentifulStockMatchesUnlimited(t *testing.T) {
+	tests := []struct {
+		sizes  []int
+		amount int
+		stock  map[int]int
+	}{
+		{sizes: []int{2, 3, 4}, amount: 6, stock: map[int]int{2: 10, 3: 10, 4: 10}},
+		{sizes: []int{2, 3, 4}, amount: 6, stock: map[int]int{7: 1}},
+		{sizes: []int{3, 4, 6, 8, 9}, amount: 28, stock: map[int]int{3: 10, 4: 10, 6: 10, 8: 10, 9: 10}},
+		{sizes: []int{250, 500, 1000, 2000, 5000}, amount: 12001, stock: map[int]int{250: 100, 5000: 100}},
+	}
+
+	for _, tt := range tests {
+		for _, objective := range []Objective{ObjectivePackages, ObjectiveCost} {
+			// One price per item, so every packing of the same items ties on cost
+			packageSizes := Packages{Sizes: tt.sizes, Prices: tt.sizes}
+
+			want, err := packageSizes.Solve(tt.amount, SolveOptions{Objective: objective})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := packageSizes.Solve(tt.amount, SolveOptions{Objective: objective, Stock: tt.stock})
+			if err != nil {
+				t.Fatalf("Solve(%v, %d, %v) error = %v", tt.sizes, tt.amount, tt.stock, err)
+			}
+
+			if !reflect.DeepEqual(got.Result, want.Result) {
+				t.Errorf("Solve(%v, %d, %s) with stock %v = %v, want %v as without stock",
+					tt.sizes, tt.amount, objective, tt.stock, got.Result, want.Result)
+			}
+		}
+	}
+}
+
+func TestSolveLines(t *testing.T) {
+	items := []LineItem{
+		{SKU: "shaker", Amount: 501, Packages: Packages{Sizes: []int{250, 500, 1000, 2000, 5000}, Prices: []int{3, 5, 9, 17, 40}}},
+		{SKU: "gloves", Amount: 12, Packages: Packages{Sizes: []int{5, 10}, Prices: []int{2, 3}}},
+	}
+
+	order, err := SolveLines(items, SolveOptions{})
+	if err != nil {
+		t.Fatalf("SolveLines() error = %v", err)
+	}
+
+	if len(order.Lines) != 2 || !reflect.DeepEqual(order.Lines[0].Order.Result, []int{250, 500}) ||
+		!reflect.DeepEqual(order.Lines[1].Order.Result, []int{5, 10}) {
+		t.Fatalf("SolveLines() lines = %+v", order.Lines)
+	}
+
+	if order.TotalAmount != 513 || order.TotalItems != 765 || order.TotalPackages != 4 || order.TotalCost != 13 {
+		t.Errorf("SolveLines() totals = %d ordered, %d items, %d packages, cost %d; want 513, 765, 4, 13",
+			order.TotalAmount, order.TotalItems, order.TotalPackages, order.TotalCost)
+	}
+}
+
+func TestSolveLinesValidation(t *testing.T) {
+	sizes := Packages{Sizes: []int{5, 10}}
+
+	tests := []struct {
+		name    string
+		items   []LineItem
+		wantErr error
+	}{
+		{name: "No lines", items: nil, wantErr: ErrNoLines},
+		{name: "Missing SKU", items: []LineItem{{Amount: 5, Packages: sizes}}, wantErr: ErrMissingSKU},
+		{
+			name:    "Duplicate SKU",
+			items:   []LineItem{{SKU: "a", Amount: 5, Packages: sizes}, {SKU: "a", Amount: 5, Packages: sizes}},
+			wantErr: ErrDuplicateSKU,
+		},
+		{
+			name:    "Invalid line",
+			items:   []LineItem{{SKU: "a", Amount: 5, Packages: sizes}, {SKU: "b", Amount: 0, Packages: sizes}},
+			wantErr: ErrNonPositiveAmount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := SolveLines(tt.items, SolveOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("SolveLines() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewPacking(t *testing.T) {
+	packing := NewPacking(12001, []int{250, 2000, 5000, 5000})
+
+	want := Packing{
+		Packs:     []PackCount{{Size: 5000, Count: 2}, {Size: 2000, Count: 1}, {Size: 250, Count: 1}},
+		Items:     12250,
+		Overshoot: 249,
+		Packages:  4,
+	}
+	if !reflect.DeepEqual(packing, want) {
+		t.Errorf("NewPacking() = %+v, want %+v", packing, want)
+	}
+
+	if flat := packing.Flatten(); !reflect.DeepEqual(flat, []int{250, 2000, 5000, 5000}) {
+		t.Errorf("Flatten() = %v, want %v", flat, []int{250, 2000, 5000, 5000})
+	}
+
+	if counts := packing.Counts(); !reflect.DeepEqual(counts, map[int]int{250: 1, 2000: 1, 5000: 2}) {
+		t.Errorf("Counts() = %v", counts)
+	}
+}
+
+func TestSolvePacking(t *testing.T) {
+	order, err := Packages{Sizes: []int{250, 500, 1000, 2000, 5000}}.Solve(501, SolveOptions{})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(order.Packing.Flatten(), order.Result) {
+		t.Errorf("Packing %+v does not match result %v", order.Packing, order.Result)
+	}
+
+	if order.Packing.Items != 750 || order.Packing.Overshoot != 249 || order.Packing.Packages != 2 {
+		t.Errorf("Solve() packing = %+v, want 750 items, 249 overshoot, 2 packages", order.Packing)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name           string
+		packageSizes   Packages
+		amount         int
+		opts           SolveOptions
+		wantChosen     int
+		wantRunnerUp   int
+		wantDecidedBy  string
+		wantConsidered int
+	}{
+		{
+			name:           "Fewer items beat fewer packages",
+			packageSizes:   Packages{Sizes: []int{250, 500, 1000, 2000, 5000}},
+			amount:         501,
+			wantChosen:     750,
+			wantRunnerUp:   1000,
+			wantDecidedBy:  "items",
+			wantConsidered: 20,
+		},
+		{
+			name:           "Lower cost beats fewer items",
+			packageSizes:   Packages{Sizes: []int{250, 500, 1000}, Prices: []int{300, 550, 800}},
+			amount:         501,
+			opts:           SolveOptions{Objective: ObjectiveCost},
+			wantChosen:     1000,
+			wantRunnerUp:   750,
+			wantDecidedBy:  "cost",
+			wantConsidered: 4,
+		},
+		{
+			name:           "Fewer items beat lower cost",
+			packageSizes:   Packages{Sizes: []int{250, 500, 1000}, Prices: []int{300, 550, 800}},
+			amount:         501,
+			wantChosen:     750,
+			wantRunnerUp:   1000,
+			wantDecidedBy:  "items",
+			wantConsidered: 4,
+		},
+		{
+			name:           "Large amount",
+			packageSizes:   Packages{Sizes: []int{250, 500, 1000, 2000, 5000}},
+			amount:         12001 + 5000*199_000,
+			wantChosen:     12250 + 5000*199_000,
+			wantRunnerUp:   12500 + 5000*199_000,
+			wantDecidedBy:  "items",
+			wantConsidered: 20,
+		},
+		{
+			name:           "Strategy without candidates",
+			packageSizes:   Packages{Sizes: []int{4, 7}},
+			amount:         8,
+			opts:           SolveOptions{Strategy: StrategyGreedy},
+			wantChosen:     11,
+			wantConsidered: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, explanation, err := tt.packageSizes.Explain(tt.amount, tt.opts)
+			if err != nil {
+				t.Fatalf("Explain() error = %v", err)
+			}
+
+			if explanation.Chosen.Items != tt.wantChosen || order.Packing.Items != tt.wantChosen {
+				t.Errorf("Explain() chose %d items and packed %d, want %d", explanation.Chosen.Items, order.Packing.Items, tt.wantChosen)
+			}
+
+			if !reflect.DeepEqual(explanation.Chosen.Packs, order.Packing.Packs) {
+				t.Errorf("Explain() chose %v but packed %v", explanation.Chosen.Packs, order.Packing.Packs)
+			}
+
+			if tt.wantRunnerUp != 0 && (len(explanation.RunnersUp) == 0 || explanation.RunnersUp[0].Items != tt.wantRunnerUp) {
+				t.Errorf("Explain() runners up = %+v, want %d items first", explanation.RunnersUp, tt.wantRunnerUp)
+			}
+
+			if explanation.DecidedBy != tt.wantDecidedBy || explanation.Considered != tt.wantConsidered {
+				t.Errorf("Explain() decided by %q among %d, want %q among %d",
+					explanation.DecidedBy, explanation.Considered, tt.wantDecidedBy, tt.wantConsidered)
+			}
+
+			if len(explanation.Candidates) != min(tt.wantConsidered, MaxExplainCandidates) {
+				t.Errorf("Explain() listed %d candidates, want %d", len(explanation.Candidates), tt.wantConsidered)
+			}
+		})
+	}
+}
+
+func TestExplainMatchesSolve(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+
+	for i := 0; i < 200; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 40)
+		prices := make([]int, len(sizes))
+		for j := range prices {
+			prices[j] = rng.Intn(100)
+		}
+		packageSizes := Packages{Sizes: sizes, Prices: prices}
+		amount := 1 + rng.Intn(300)
+
+		for _, objective := range []Objective{ObjectivePackages, ObjectiveCost} {
+			opts := SolveOptions{Objective: objective}
+
+			want, err := packageSizes.Solve(amount, opts)
+			if err != nil {
+				t.Fatalf("Solve(%v, %d) error = %v", sizes, amount, err)
+			}
+
+			got, explanation, err := packageSizes.Explain(amount, opts)
+			if err != nil {
+				t.Fatalf("Explain(%v, %d) error = %v", sizes, amount, err)
+			}
+
+			if explanation.Chosen.Cost != got.Cost {
+				t.Errorf("Explain(%v, %v, %d, %s) chose a cost of %d for an order costing %d", sizes, prices, amount, objective, explanation.Chosen.Cost, got.Cost)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Explain(%v, %v, %d, %s) = %+v, Solve() = %+v", sizes, prices, amount, objective, got, want)
+			}
+		}
+	}
+}
+
+func TestAlternatives(t *testing.T) {
+	tests := []struct {
+		name         string
+		packageSizes Packages
+		amount       int
+		k            int
+		want         [][]int
+	}{
+		{
+			name:         "Next best after overshoot",
+			packageSizes: Packages{Sizes: []int{250, 500, 1000, 2000, 5000}},
+			amount:       501,
+			k:            3,
+			want:         [][]int{{250, 500}, {250, 250, 250}, {1000}},
+		},
+		{
+			name:         "Packings with a spare package are skipped",
+			packageSizes: Packages{Sizes: []int{4, 7}},
+			amount:       8,
+			k:            5,
+			want:         [][]int{{4, 4}, {4, 7}, {7, 7}},
+		},
+		{
+			name:         "Same total with more packages",
+			packageSizes: Packages{Sizes: []int{2, 3}},
+			amount:       6,
+			k:            2,
+			want:         [][]int{{3, 3}, {2, 2, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := tt.packageSizes.Alternatives(tt.amount, tt.k)
+			if err != nil {
+				t.Fatalf("Alternatives() error = %v", err)
+			}
+
+			got := make([][]int, len(orders))
+			for i, order := range orders {
+				got[i] = order.Result
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Alternatives() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (Packages{Sizes: []int{5}}).Alternatives(10, MaxAlternatives+1); !errors.Is(err, ErrInvalidAlternatives) {
+		t.Errorf("Alternatives() error = %v, want %v", err, ErrInvalidAlternatives)
+	}
+}
+
+func TestAlternativesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+
+	for i := 0; i < 300; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 30)
+		amount := 1 + rng.Intn(200)
+		k := 1 + rng.Intn(MaxAlternatives)
+		if i%10 == 0 {
+			amount += 100_000
+		}
+
+		orders, err := Packages{Sizes: sizes}.Alternatives(amount, k)
+		if err != nil {
+			t.Fatalf("Alternatives(%v, %d) error = %v", sizes, amount, err)
+		}
+
+		if best, _ := (Packages{Sizes: sizes}).Solve(amount, SolveOptions{}); !reflect.DeepEqual(orders[0].Packing, best.Packing) {
+			t.Errorf("Alternatives(%v, %d)[0] = %+v, Solve() = %+v", sizes, amount, orders[0].Packing, best.Packing)
+		}
+
+		if amount > 100_000 {
+			continue
+		}
+
+		want := bruteForceAlternatives(sizes, amount)
+		want = want[:min(k, len(want))]
+
+		got := make([][2]int, len(orders))
+		for j, order := range orders {
+			got[j] = [2]int{order.Packing.Overshoot, order.Packing.Packages}
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Alternatives(%v, %d, %d) = %v, want %v", sizes, amount, k, got, want)
+		}
+	}
+}
+
+func TestShip(t *testing.T) {
+	packageSizes := Packages{
+		Sizes:   []int{250, 500, 1000, 2000, 5000},
+		Weights: []int{300, 550, 1000, 1900, 4500},
+	}
+	packing := NewPacking(12001, []int{250, 2000, 5000, 5000})
+
+	tests := []struct {
+		name    string
+		limits  ParcelLimits
+		want    [][]PackCount
+		wantErr error
+	}{
+		{
+			name:   "No limits",
+			limits: ParcelLimits{},
+			want:   [][]PackCount{{{Size: 5000, Count: 2}, {Size: 2000, Count: 1}, {Size: 250, Count: 1}}},
+		},
+		{
+			name:   "Package count",
+			limits: ParcelLimits{MaxPackages: 2},
+			want:   [][]PackCount{{{Size: 5000, Count: 2}}, {{Size: 2000, Count: 1}, {Size: 250, Count: 1}}},
+		},
+		{
+			name:   "Weight",
+			limits: ParcelLimits{MaxWeight: 5000},
+			want: [][]PackCount{
+				{{Size: 5000, Count: 1}, {Size: 250, Count: 1}},
+				{{Size: 5000, Count: 1}},
+				{{Size: 2000, Count: 1}},
+			},
+		},
+		{name: "Package too heavy", limits: ParcelLimits{MaxWeight: 4000}, wantErr: ErrPackageTooHeavy},
+		{name: "Negative limit", limits: ParcelLimits{MaxPackages: -1}, wantErr: ErrInvalidLimits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shipments, err := packageSizes.Ship(packing, tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Ship() error = %v, want %v", err, tt.wantErr)
+			}
+
+			got := make([][]PackCount, len(shipments))
+			for i, shipment := range shipments {
+				got[i] = shipment.Packs
+			}
+
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Ship() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (Packages{Sizes: []int{5}}).Ship(packing, ParcelLimits{MaxWeight: 10}); !errors.Is(err, ErrMissingWeights) {
+		t.Errorf("Ship() error = %v, want %v", err, ErrMissingWeights)
+	}
+
+	huge := Packing{Packs: []PackCount{{Size: 5000, Count: 200_000}}, Items: MaxAmount, Packages: 200_000}
+	if _, err := packageSizes.Ship(huge, ParcelLimits{MaxPackages: 1}); !errors.Is(err, ErrTooManyShipments) {
+		t.Errorf("Ship() error = %v, want %v", err, ErrTooManyShipments)
+	}
+}
+
+func TestShipRespectsLimits(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+
+	for i := 0; i < 300; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(5), 100)
+		weights := make([]int, len(sizes))
+		for j := range weights {
+			weights[j] = rng.Intn(50)
+		}
+		packageSizes := Packages{Sizes: sizes, Weights: weights}
+
+		order, err := packageSizes.Solve(1+rng.Intn(5000), SolveOptions{})
+		if err != nil {
+			t.Fatalf("Solve() error = %v", err)
+		}
+
+		limits := ParcelLimits{MaxPackages: rng.Intn(10), MaxWeight: 50 + rng.Intn(200)}
+		shipments, err := packageSizes.Ship(order.Packing, limits)
+		if err != nil {
+			t.Fatalf("Ship(%v, %+v) error = %v", order.Packing, limits, err)
+		}
+
+		shipped := make(map[int]int)
+		for _, shipment := range shipments {
+			if limits.MaxPackages > 0 && shipment.Packages > limits.MaxPackages || shipment.Weight > limits.MaxWeight {
+				t.Errorf("Ship(%v, %+v) made parcel %+v", order.Packing, limits, shipment)
+			}
+
+			for _, pack := range shipment.Packs {
+				shipped[pack.Size] += pack.Count
+			}
+		}
+
+		if !reflect.DeepEqual(shipped, order.Packing.Counts()) {
+			t.Errorf("Ship(%v) shipped %v", order.Packing, shipped)
+		}
+
+		// Without a weight to fill, only the package count decides
+		if limits.MaxPackages > 0 {
+			onlyCount, err := packageSizes.Ship(order.Packing, ParcelLimits{MaxPackages: limits.MaxPackages})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := (order.Packing.Packages + limits.MaxPackages - 1) / limits.MaxPackages; len(onlyCount) != want {
+				t.Errorf("Ship(%v, %d packages) made %d parcels, want %d", order.Packing, limits.MaxPackages, len(onlyCount), want)
+			}
+		}
+	}
+}
+
+func TestSolveHierarchy(t *testing.T) {
+	packageSizes := Packages{
+		Sizes: []int{250, 500, 1000, 2000, 5000},
+		Hierarchy: &Hierarchy{
+			PackSize: 5000,
+			Levels:   []Level{{Name: "carton", Holds: 4}, {Name: "pallet", Holds: 5}},
+		},
+	}
+
+	order, err := packageSizes.Solve(265001, SolveOptions{})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	pack := Unit{Level: PackLevel, Items: 5000, Count: 4}
+	carton := Unit{Level: "carton", Items: 20000, Count: 5, Contents: []Unit{pack}}
+	want := []Unit{
+		{Level: "pallet", Items: 100000, Count: 2, Contents: []Unit{carton}},
+		{Level: "carton", Items: 20000, Count: 3, Contents: []Unit{pack}},
+		{Level: PackLevel, Items: 5000, Count: 1},
+		{Level: PackLevel, Items: 250, Count: 1},
+	}
+
+	if !reflect.DeepEqual(order.Tree, want) {
+		t.Errorf("Solve() tree = %+v, want %+v", order.Tree, want)
+	}
+
+	if order, _ := (Packages{Sizes: packageSizes.Sizes}).Solve(265001, SolveOptions{}); order.Tree != nil {
+		t.Errorf("Solve() without a hierarchy returned tree %+v", order.Tree)
+	}
+}
+
+func TestSolveHierarchyEverySize(t *testing.T) {
+	packageSizes := Packages{
+		Sizes:     []int{250, 500, 1000},
+		Hierarchy: &Hierarchy{Levels: []Level{{Name: "carton", Holds: 2}}},
+	}
+
+	// 7 1000-packs, 1 500-pack and 1 250-pack
+	order, err := packageSizes.Solve(7750, SolveOptions{})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	want := []Unit{
+		{Level: "carton", Items: 2000, Count: 3, Contents: []Unit{{Level: PackLevel, Items: 1000, Count: 2}}},
+		{Level: PackLevel, Items: 1000, Count: 1},
+		{Level: PackLevel, Items: 500, Count: 1},
+		{Level: PackLevel, Items: 250, Count: 1},
+	}
+
+	if !reflect.DeepEqual(order.Tree, want) {
+		t.Errorf("Solve() tree = %+v, want %+v", order.Tree, want)
+	}
+
+	// 2 1000-packs and 2 300-packs, so both sizes fill a carton
+	packageSizes.Sizes = []int{300, 1000}
+	order, err = packageSizes.Solve(2600, SolveOptions{})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	want = []Unit{
+		{Level: "carton", Items: 2000, Count: 1, Contents: []Unit{{Level: PackLevel, Items: 1000, Count: 2}}},
+		{Level: "carton", Items: 600, Count: 1, Contents: []Unit{{Level: PackLevel, Items: 300, Count: 2}}},
+	}
+
+	if !reflect.DeepEqual(order.Tree, want) {
+		t.Errorf("Solve() tree = %+v, want %+v", order.Tree, want)
+	}
+}
+
+func TestHierarchyValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		hierarchy Hierarchy
+	}{
+		{name: "Unknown pack size", hierarchy: Hierarchy{PackSize: 300, Levels: []Level{{Name: "carton", Holds: 4}}}},
+		{name: "No levels", hierarchy: Hierarchy{PackSize: 250}},
+		{name: "Missing name", hierarchy: Hierarchy{PackSize: 250, Levels: []Level{{Holds: 4}}}},
+		{name: "Duplicate name", hierarchy: Hierarchy{PackSize: 250, Levels: []Level{{Name: "box", Holds: 4}, {Name: "box", Holds: 2}}}},
+		{name: "Reserved name", hierarchy: Hierarchy{PackSize: 250, Levels: []Level{{Name: PackLevel, Holds: 4}}}},
+		{name: "Holds nothing", hierarchy: Hierarchy{PackSize: 250, Levels: []Level{{Name: "carton", Holds: 0}}}},
+		{name: "Too large", hierarchy: Hierarchy{PackSize: 250, Levels: []Level{{Name: "carton", Holds: 1000}, {Name: "pallet", Holds: 1_000_000}}}},
+		{name: "Too large for the largest size", hierarchy: Hierarchy{Levels: []Level{{Name: "carton", Holds: MaxAmount/500 + 1}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Packages{Sizes: []int{250, 500}, Hierarchy: &tt.hierarchy}.Validate()
+			if !errors.Is(err, ErrInvalidHierarchy) {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidHierarchy)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name          string
+		sizes         []int
+		from, to      int
+		wantGCD       int
+		wantFrobenius int // -2 when there is none
+		wantWorst     int
+		wantWorstAt   int
+		wantAverage   float64
+		wantUnused    []int
+	}{
+		{
+			name:          "Standard sizes",
+			sizes:         []int{250, 500, 1000, 2000, 5000},
+			from:          1,
+			to:            10000,
+			wantGCD:       250,
+			wantFrobenius: -2,
+			wantWorst:     249,
+			wantWorstAt:   1,
+			wantAverage:   124.5,
+			wantUnused:    []int{},
+		},
+		{
+			name:          "Small orders never need large packs",
+			sizes:         []int{250, 500, 1000, 2000, 5000},
+			from:          1,
+			to:            100,
+			wantGCD:       250,
+			wantFrobenius: -2,
+			wantWorst:     249,
+			wantWorstAt:   1,
+			wantAverage:   199.5,
+			wantUnused:    []int{500, 1000, 2000, 5000},
+		},
+		{
+			name:          "Chicken nuggets",
+			sizes:         []int{20, 9, 6},
+			from:          40,
+			to:            45,
+			wantGCD:       1,
+			wantFrobenius: 43,
+			wantWorst:     1,
+			wantWorstAt:   43,
+			wantAverage:   1.0 / 6,
+			wantUnused:    []int{},
+		},
+		{
+			name:          "Every amount reachable",
+			sizes:         []int{1, 5},
+			from:          1,
+			to:            10,
+			wantGCD:       1,
+			wantFrobenius: -1,
+			wantUnused:    []int{},
+			wantWorstAt:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Packages{Sizes: tt.sizes}.Analyze(tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+
+			frobenius := -2
+			if a.Frobenius != nil {
+				frobenius = *a.Frobenius
+			}
+
+			if a.GCD != tt.wantGCD || frobenius != tt.wantFrobenius {
+				t.Errorf("Analyze() GCD = %d, Frobenius = %d, want %d, %d", a.GCD, frobenius, tt.wantGCD, tt.wantFrobenius)
+			}
+
+			if a.WorstOvershoot != tt.wantWorst || a.WorstAmount != tt.wantWorstAt || a.AverageOvershoot != tt.wantAverage {
+				t.Errorf("Analyze() overshoot = %d at %d, average %v, want %d at %d, average %v",
+					a.WorstOvershoot, a.WorstAmount, a.AverageOvershoot, tt.wantWorst, tt.wantWorstAt, tt.wantAverage)
+			}
+
+			if !reflect.DeepEqual(a.UnusedSizes, tt.wantUnused) {
+				t.Errorf("Analyze() unused sizes = %v, want %v", a.UnusedSizes, tt.wantUnused)
+			}
+
+			if len(a.Curve) == 0 || len(a.Curve) > MaxCurvePoints || a.Curve[0].From != tt.from || a.Curve[len(a.Curve)-1].To != tt.to {
+				t.Errorf("Analyze() curve = %+v, want up to %d points from %d to %d", a.Curve, MaxCurvePoints, tt.from, tt.to)
+			}
+		})
+	}
+
+	if _, err := (Packages{Sizes: []int{5}}).Analyze(10, 1); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Analyze() error = %v, want %v", err, ErrInvalidRange)
+	}
+}
+
+func TestFrobeniusBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+
+	for i := 0; i < 300; i++ {
+		sizes := randomSizes(rng, 2+rng.Intn(3), 60)
+		sort.Ints(sizes)
+
+		g := 0
+		for _, size := range sizes {
+			g = gcd(g, size)
+		}
+		if g != 1 {
+			continue
+		}
+
+		// Every total beyond sizes[0]*sizes[len-1] is reachable
+		limit := sizes[0] * sizes[len(sizes)-1]
+		table, err := newPackingTable(context.Background(), sizes, nil, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := -1
+		for total := limit; total > 0; total-- {
+			if !table.reachable(total) {
+				want = total
+				break
+			}
+		}
+
+		if got := frobeniusNumber(sizes); got != want {
+			t.Errorf("frobeniusNumber(%v) = %d, want %d", sizes, got, want)
+		}
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	var amounts []int
+	for i := 0; i < 10; i++ {
+		amounts = append(amounts, 501, 501, 12001, 250)
+	}
+	current := []int{250, 500, 1000, 2000, 5000}
+
+	tests := []struct {
+		name          string
+		opts          RecommendOptions
+		wantSizes     []int
+		wantOvershoot int
+	}{
+		{
+			name:          "Common amounts become sizes",
+			opts:          RecommendOptions{Count: 3},
+			wantSizes:     []int{250, 501, 12001},
+			wantOvershoot: 0,
+		},
+		{
+			name:          "Required size is kept",
+			opts:          RecommendOptions{Count: 2, Include: []int{5000}},
+			wantSizes:     []int{501, 5000},
+			wantOvershoot: 10*(501-250) + 10*(12004-12001),
+		},
+		{
+			name:          "Only the given candidates",
+			opts:          RecommendOptions{Count: 2, Candidates: []int{250, 500, 1000}},
+			wantSizes:     []int{250, 1000},
+			wantOvershoot: 20*249 + 10*249,
+		},
+		{
+			name:          "Packages weighed against overshoot",
+			opts:          RecommendOptions{Count: 2, Candidates: []int{250, 500, 1000}, PackageCost: 1000},
+			wantSizes:     []int{500, 1000},
+			wantOvershoot: 20*499 + 10*499 + 10*250,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Recommend(amounts, current, tt.opts)
+			if err != nil {
+				t.Fatalf("Recommend() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(r.Recommended.Sizes, tt.wantSizes) || r.Recommended.Overshoot != tt.wantOvershoot {
+				t.Errorf("Recommend() = %+v, want sizes %v with overshoot %d", r.Recommended, tt.wantSizes, tt.wantOvershoot)
+			}
+
+			if r.Current == nil || r.OvershootSaved != r.Current.Overshoot-r.Recommended.Overshoot || r.Orders != len(amounts) || r.ScoredOrders != len(amounts) {
+				t.Errorf("Recommend() = %+v, want savings against the current sizes", r)
+			}
+		})
+	}
+
+	t.Run("More amounts than are scored", func(t *testing.T) {
+		var many []int
+		for amount := 1; amount <= maxRecommendAmounts+100; amount++ {
+			many = append(many, amount)
+		}
+		many = append(many, 7, 7, 7)
+
+		r, err := Recommend(many, []int{5, 10}, RecommendOptions{Count: 2})
+		if err != nil {
+			t.Fatalf("Recommend() error = %v", err)
+		}
+
+		if r.Orders != len(many) || r.ScoredOrders != maxRecommendAmounts+3 {
+			t.Errorf("Recommend() scored %d of %d orders, want %d of %d", r.ScoredOrders, r.Orders, maxRecommendAmounts+3, len(many))
+		}
+
+		saved := r.Current.Overshoot - r.Recommended.Overshoot
+		if want := saved * r.Orders / r.ScoredOrders; r.OvershootSaved != want {
+			t.Errorf("Recommend() saved %d overshoot, want %d scaled from %d", r.OvershootSaved, want, saved)
+		}
+	})
+
+	t.Run("Sets too large to score", func(t *testing.T) {
+		r, err := Recommend([]int{100, 200}, []int{99989, 99991}, RecommendOptions{Count: 2, Candidates: []int{50, 100, 99989, 99991}})
+		if err != nil {
+			t.Fatalf("Recommend() error = %v", err)
+		}
+
+		if r.SkippedSets == 0 || r.Current != nil {
+			t.Errorf("Recommend() = %+v, want skipped sets and no current score", r)
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		amounts []int
+		opts    RecommendOptions
+		wantErr error
+	}{
+		{name: "No history", opts: RecommendOptions{Count: 3}, wantErr: ErrNoHistory},
+		{name: "No sizes", amounts: amounts, opts: RecommendOptions{}, wantErr: ErrInvalidRecommendation},
+		{name: "Too many sizes", amounts: amounts, opts: RecommendOptions{Count: MaxRecommendSizes + 1}, wantErr: ErrInvalidRecommendation},
+		{name: "Too many required", amounts: amounts, opts: RecommendOptions{Count: 1, Include: []int{250, 500}}, wantErr: ErrInvalidRecommendation},
+		{name: "Negative package cost", amounts: amounts, opts: RecommendOptions{Count: 2, PackageCost: -1}, wantErr: ErrInvalidRecommendation},
+		{name: "Invalid required size", amounts: amounts, opts: RecommendOptions{Count: 2, Include: []int{-5}}, wantErr: ErrInvalidSize},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Recommend(tt.amounts, current, tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Recommend() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSolveBatchMatchesSolve(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+
+	for i := 0; i < 100; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 40)
+		prices := make([]int, len(sizes))
+		for j := range prices {
+			prices[j] = rng.Intn(100)
+		}
+		packageSizes := Packages{Sizes: sizes, Prices: prices}
+
+		amounts := make([]int, 1+rng.Intn(20))
+		for j := range amounts {
+			amounts[j] = 1 + rng.Intn(2000)
+		}
+		amounts = append(amounts, MaxAmount-rng.Intn(1000))
+
+		for _, opts := range []SolveOptions{{}, {Objective: ObjectiveCost}, {Strategy: StrategyGreedy}} {
+			orders, err := packageSizes.SolveBatch(amounts, opts)
+			if err != nil {
+				t.Fatalf("SolveBatch(%v, %v) error = %v", sizes, amounts, err)
+			}
+
+			for j, amount := range amounts {
+				want, err := packageSizes.Solve(amount, opts)
+				if err != nil {
+					t.Fatalf("Solve(%v, %d) error = %v", sizes, amount, err)
+				}
+
+				if !reflect.DeepEqual(orders[j], want) {
+					t.Fatalf("SolveBatch(%v, %v, %+v)[%d] = %+v, Solve() = %+v", sizes, prices, opts, j, orders[j], want)
+				}
+			}
+		}
+	}
+}
+
+func TestSolveBatchValidation(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{250, 500, 1000}}
+
+	tests := []struct {
+		name    string
+		amounts []int
+		wantErr error
+	}{
+		{name: "No amounts", wantErr: ErrInvalidBatch},
+		{name: "Too many amounts", amounts: make([]int, MaxBatchAmounts+1), wantErr: ErrInvalidBatch},
+		{name: "Invalid later amount", amounts: []int{501, 0}, wantErr: ErrNonPositiveAmount},
+		{name: "Amount too large", amounts: []int{MaxAmount + 1, 501}, wantErr: ErrAmountTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := packageSizes.SolveBatch(tt.amounts, SolveOptions{}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("SolveBatch() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSolveBatchContext(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{23, 31, 53}}
+	amounts := []int{500000, 12001}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := packageSizes.SolveBatchContext(cancelled, amounts, SolveOptions{}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("SolveBatchContext() error = %v, want %v", err, ErrCancelled)
+	}
+
+	for _, strategy := range []string{StrategyExact, StrategyGreedy} {
+		orders, err := packageSizes.SolveBatchContext(context.Background(), amounts, SolveOptions{Strategy: strategy, Budget: time.Nanosecond})
+		if err != nil {
+			t.Fatalf("SolveBatchContext(%s) error = %v", strategy, err)
+		}
+
+		for i, order := range orders {
+			if !order.Approximate || order.Packing.Items < amounts[i] {
+				t.Errorf("SolveBatchContext(%s) = %+v, want an approximate packing of at least %d items", strategy, order.Packing, amounts[i])
+			}
+		}
+	}
+
+	stock := SolveOptions{Budget: time.Nanosecond, Stock: map[int]int{53: 100}}
+	if _, err := packageSizes.SolveBatchContext(context.Background(), amounts, stock); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("SolveBatchContext() error = %v, want %v", err, ErrBudgetExceeded)
+	}
+}
+
+func TestCatalogueMatchesSolve(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+
+	for i := 0; i < 100; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 60)
+		prices := make([]int, len(sizes))
+		for j := range prices {
+			prices[j] = rng.Intn(100)
+		}
+		packageSizes := Packages{Sizes: sizes, Prices: prices}
+
+		catalogue, err := CompileCatalogue(packageSizes)
+		if err != nil {
+			t.Fatalf("CompileCatalogue(%v) error = %v", sizes, err)
+		}
+
+		options := []SolveOptions{
+			{},
+			{Objective: ObjectiveCost},
+			{Strategy: StrategyCost},
+			{Strategy: StrategyHeuristic},
+			{Stock: map[int]int{sizes[0]: 3}},
+			{TieBreak: TieFewestSizes},
+			{Objective: ObjectiveCost, TieBreak: TieSmallerPacks},
+		}
+
+		for _, amount := range []int{1 + rng.Intn(5000), MaxAmount - rng.Intn(1000), 0} {
+			for _, opts := range options {
+				want, wantErr := packageSizes.Solve(amount, opts)
+				got, err := catalogue.Solve(amount, opts)
+				if (err == nil) != (wantErr == nil) || (err != nil && err.Error() != wantErr.Error()) {
+					t.Fatalf("Catalogue(%v).Solve(%d, %+v) error = %v, want %v", sizes, amount, opts, err, wantErr)
+				}
+
+				wantJSON, _ := json.Marshal(want)
+				gotJSON, _ := json.Marshal(got)
+				if !bytes.Equal(gotJSON, wantJSON) {
+					t.Fatalf("Catalogue(%v, %v).Solve(%d, %+v) = %s, want %s", sizes, prices, amount, opts, gotJSON, wantJSON)
+				}
+			}
+		}
+	}
+}
+
+func TestCatalogueCompilesLazily(t *testing.T) {
+	catalogue, err := CompileCatalogue(Packages{Sizes: []int{250, 500, 1000}, Prices: []int{3, 5, 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes := catalogue.Bytes(); bytes != 0 {
+		t.Errorf("Bytes() before any query = %d, want 0", bytes)
+	}
+
+	if _, err := catalogue.Solve(12001, SolveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	packages := catalogue.Bytes()
+	if packages == 0 {
+		t.Errorf("Bytes() after a query = 0, want the packages table")
+	}
+
+	if _, err := catalogue.Solve(12001, SolveOptions{Objective: ObjectiveCost}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes := catalogue.Bytes(); bytes <= packages {
+		t.Errorf("Bytes() after a cost query = %d, want more than %d", bytes, packages)
+	}
+}
+
+func TestSolveTieBreak(t *testing.T) {
+	// 28 items fit in 29 with four packages in several ways
+	packageSizes := Packages{Sizes: []int{3, 4, 6, 8, 9}}
+
+	tests := []struct {
+		tie  TieBreak
+		want []int
+	}{
+		{tie: "", want: []int{4, 6, 9, 9}},
+		{tie: TieLargerPacks, want: []int{4, 6, 9, 9}},
+		{tie: TieSmallerPacks, want: []int{6, 6, 8, 8}},
+		{tie: TieFewestSizes, want: []int{4, 8, 8, 8}},
+		{tie: TieLexicographic, want: []int{3, 8, 8, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.tie), func(t *testing.T) {
+			order, err := packageSizes.Solve(28, SolveOptions{TieBreak: tt.tie})
+			if err != nil {
+				t.Fatalf("Solve() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(order.Result, tt.want) {
+				t.Errorf("Solve() = %v, want %v", order.Result, tt.want)
+			}
+		})
+	}
+}
+
+func TestTieBreakMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+
+	for i := 0; i < 300; i++ {
+		sizes := randomSizes(rng, 2+rng.Intn(3), 15)
+		amount := 1 + rng.Intn(60)
+
+		// Every packing with the fewest items and then the fewest packages
+		var ties [][]int
+		bestTotal, bestCount := bruteForcePacking(sizes, amount)
+		eachCover(sizes, nil, amount, func(counts []int) {
+			var packages []int
+			for j := len(sizes) - 1; j >= 0; j-- {
+				for n := 0; n < counts[j]; n++ {
+					packages = append(packages, sizes[j])
+				}
+			}
+			sort.Ints(packages)
+
+			if sum(packages) == bestTotal && len(packages) == bestCount {
+				ties = append(ties, packages)
+			}
+		})
+
+		descending := func(a, b []int) int {
+			for j := len(a) - 1; j >= 0; j-- {
+				if a[j] != b[j] {
+					return a[j] - b[j]
+				}
+			}
+			return 0
+		}
+		distinct := func(a []int) int {
+			n := 0
+			for j := range a {
+				if j == 0 || a[j] != a[j-1] {
+					n++
+				}
+			}
+			return n
+		}
+		// beats[tie] reports whether packing a wins over b under the policy
+		beats := map[TieBreak]func(a, b []int) bool{
+			TieLargerPacks:  func(a, b []int) bool { return descending(a, b) > 0 },
+			TieSmallerPacks: func(a, b []int) bool { return descending(a, b) < 0 },
+			TieFewestSizes: func(a, b []int) bool {
+				if distinct(a) != distinct(b) {
+					return distinct(a) < distinct(b)
+				}
+				return descending(a, b) > 0
+			},
+			TieLexicographic: func(a, b []int) bool {
+				for j := range a {
+					if a[j] != b[j] {
+						return a[j] < b[j]
+					}
+				}
+				return false
+			},
+		}
+
+		for tie, better := range beats {
+			want := ties[0]
+			for _, packages := range ties[1:] {
+				if better(packages, want) {
+					want = packages
+				}
+			}
+
+			order, err := Packages{Sizes: sizes}.Solve(amount, SolveOptions{TieBreak: tie})
+			if err != nil {
+				t.Fatalf("Solve(%v, %d, %s) error = %v", sizes, amount, tie, err)
+			}
+
+			if !reflect.DeepEqual(order.Result, want) {
+				t.Fatalf("Solve(%v, %d, %s) = %v, want %v of %v", sizes, amount, tie, order.Result, want, ties)
+			}
+		}
+	}
+}
+
+func TestSolveLargeAmount(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{250, 500, 1000, 2000, 5000}}
+
+	order, err := packageSizes.Solve(12001+5000*199_000, SolveOptions{})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	want := []PackCount{{Size: 5000, Count: 199_002}, {Size: 2000, Count: 1}, {Size: 250, Count: 1}}
+	if !reflect.DeepEqual(order.Packing.Packs, want) || order.Packing.Overshoot != 249 {
+		t.Errorf("Solve() packing = %+v, want %v with overshoot 249", order.Packing, want)
+	}
+
+	if order.Result != nil {
+		t.Errorf("Solve() listed %d packages, want none above %d", len(order.Result), MaxResultPackages)
+	}
+
+	if _, err := packageSizes.Pack(MaxAmount); !errors.Is(err, ErrTooManyPackages) {
+		t.Errorf("Pack() error = %v, want %v", err, ErrTooManyPackages)
+	}
+}
+
+func TestSolveReductionMatchesFullTable(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+
+	for i := 0; i < 300; i++ {
+		sizes := randomSizes(rng, 1+rng.Intn(4), 30)
+		prices := make([]int, len(sizes))
+		for j := range prices {
+			prices[j] = rng.Intn(100)
+		}
+		amount := 1 + rng.Intn(5000)
+
+		for _, objective := range []Objective{ObjectivePackages, ObjectiveCost} {
+			counts, err := bestPacking(sizes, prices, amount, objective)
+			if err != nil {
+				t.Fatalf("bestPacking(%v, %v, %d, %s) error = %v", sizes, prices, amount, objective, err)
+			}
+
+			got := newPackingFromCounts(amount, counts)
+			want := fullTablePacking(sizes, prices, amount, objective)
+			gotCost := Packages{Sizes: sizes, Prices: prices}.costOf(counts)
+			wantCost := Packages{Sizes: sizes, Prices: prices}.costOf(want.Counts())
+			if objective != ObjectiveCost {
+				gotCost, wantCost = 0, 0
+			}
+
+			if got.Items != want.Items || got.Packages != want.Packages || gotCost != wantCost {
+				t.Fatalf("bestPacking(%v, %v, %d, %s) = %+v costing %d, want %+v costing %d",
+					sizes, prices, amount, objective, got, gotCost, want, wantCost)
+			}
+		}
+	}
+}
+
+func TestSolveWideCatalogue(t *testing.T) {
+	tests := []struct {
+		name    string
+		sizes   []int
+		amount  int
+		opts    SolveOptions
+		want    []PackCount
+		wantErr error
+	}{
+		{
+			name:   "close coprime sizes",
+			sizes:  []int{4999, 5000},
+			amount: 30_000_000,
+			want:   []PackCount{{Size: 5000, Count: 6000}},
+		},
+		{
+			name:   "close coprime sizes at the largest amounts",
+			sizes:  []int{4999, 5000},
+			amount: 999_998_766,
+			want:   []PackCount{{Size: 5000, Count: 198_766}, {Size: 4999, Count: 1234}},
+		},
+		{
+			name:   "close coprime primes",
+			sizes:  []int{9973, 10000},
+			amount: MaxAmount - 1,
+			want:   []PackCount{{Size: 10000, Count: 97_045}, {Size: 9973, Count: 2963}},
+		},
+		{
+			name:   "close coprime primes by cost",
+			sizes:  []int{9973, 10000},
+			amount: MaxAmount - 1,
+			opts:   SolveOptions{Objective: ObjectiveCost},
+			want:   []PackCount{{Size: 10000, Count: 97_045}, {Size: 9973, Count: 2963}},
+		},
+		{
+			name:    "below the amounts the residues cover",
+			sizes:   []int{4999, 5000},
+			amount:  20_000_000,
+			wantErr: ErrCatalogueTooWide,
+		},
+		{
+			name:    "other tie-breaks",
+			sizes:   []int{4999, 5000},
+			amount:  30_000_000,
+			opts:    SolveOptions{TieBreak: TieSmallerPacks},
+			wantErr: ErrCatalogueTooWide,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One price per item, so the cheapest packing is also the fewest items
+			packageSizes := Packages{Sizes: tt.sizes, Prices: tt.sizes}
+			order, err := packageSizes.Solve(tt.amount, tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Solve(%d) error = %v, want %v", tt.amount, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Solve(%d) error = %v", tt.amount, err)
+			}
+
+			if !reflect.DeepEqual(order.Packing.Packs, tt.want) || order.Packing.Overshoot != 0 {
+				t.Errorf("Solve(%d) packing = %+v, want %v with no overshoot", tt.amount, order.Packing, tt.want)
+			}
+
+			catalogue, err := CompileCatalogue(packageSizes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := catalogue.Solve(tt.amount, tt.opts); err != nil || !reflect.DeepEqual(got, order) {
+				t.Errorf("Catalogue.Solve(%d) = %+v, %v, want %+v", tt.amount, got.Packing, err, order.Packing)
+			}
+		})
+	}
+}
+
+func TestResiduesMatchTable(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+
+	for i := 0; i < 300; i++ {
+		sizes := randomSizes(rng, 2+rng.Intn(3), 40)
+		prices := make([]int, len(sizes))
+		for j := range prices {
+			prices[j] = rng.Intn(100)
+		}
+
+		for _, objective := range []Objective{ObjectivePackages, ObjectiveCost} {
+			// A limit of one pivot's worth of totals forces the residues
+			priced := prices
+			if objective != ObjectiveCost {
+				priced = nil
+			}
+			r := newReduction(sizes, priced, objective)
+			residues, err := newResidueTable(context.Background(), sizes, priced, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			amount := residues.floor + rng.Intn(3000)
+
+			w, err := newSharedWindow(context.Background(), sizes, prices, []int{amount}, objective, r.pivot)
+			if err != nil {
+				t.Fatalf("newSharedWindow(%v, %d, %s) error = %v", sizes, amount, objective, err)
+			}
+			if w.residues == nil {
+				continue
+			}
+			w.seek(amount)
+			counts := w.packages(w.best())
+
+			want, err := bestPacking(sizes, prices, amount, objective)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, wantPacking := newPackingFromCounts(amount, counts), newPackingFromCounts(amount, want)
+			gotCost := Packages{Sizes: sizes, Prices: prices}.costOf(counts)
+			wantCost := Packages{Sizes: sizes, Prices: prices}.costOf(want)
+			if objective == ObjectivePackages && !reflect.DeepEqual(got, wantPacking) ||
+				got.Items != wantPacking.Items || got.Packages != wantPacking.Packages ||
+				objective == ObjectiveCost && gotCost != wantCost {
+				t.Fatalf("residues(%v, %v, %d, %s) = %+v costing %d, want %+v costing %d",
+					sizes, prices, amount, objective, got, gotCost, wantPacking, wantCost)
+			}
+		}
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizes     []int
+		amount    int
+		strategy  string
+		wantItems int
+		wantPacks int
+	}{
+		{name: "Greedy overshoots", sizes: []int{4, 7}, amount: 8, strategy: StrategyGreedy, wantItems: 11, wantPacks: 2},
+		{name: "Exact", sizes: []int{4, 7}, amount: 8, strategy: StrategyExact, wantItems: 8, wantPacks: 2},
+		{name: "Greedy misses exact total", sizes: []int{23, 31, 53}, amount: 500000, strategy: StrategyGreedy, wantItems: 500003, wantPacks: 9435},
+		{name: "Heuristic", sizes: []int{250, 500, 1000, 2000, 5000}, amount: 12001, strategy: StrategyHeuristic, wantItems: 12250, wantPacks: 4},
+		{name: "Heuristic huge order", sizes: []int{9999, 10000}, amount: MaxAmount, strategy: StrategyHeuristic, wantItems: MaxAmount, wantPacks: 100000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := Packages{Sizes: tt.sizes}.Solve(tt.amount, SolveOptions{Strategy: tt.strategy})
+			if err != nil {
+				t.Fatalf("Solve() error = %v", err)
+			}
+
+			if order.Packing.Items != tt.wantItems || order.Packing.Packages != tt.wantPacks {
+				t.Errorf("Solve() = %d items in %d packages, want %d items in %d packages",
+					order.Packing.Items, order.Packing.Packages, tt.wantItems, tt.wantPacks)
+			}
+		})
+	}
+}
+
+func TestStrategyValidation(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{5, 10}, Prices: []int{1, 2}}
+
+	tests := []struct {
+		name    string
+		opts    SolveOptions
+		wantErr error
+	}{
+		{name: "Unknown strategy", opts: SolveOptions{Strategy: "fastest"}, wantErr: ErrUnknownStrategy},
+		{name: "Greedy cannot minimise cost", opts: SolveOptions{Strategy: StrategyGreedy, Objective: ObjectiveCost}, wantErr: ErrUnsupportedOption},
+		{name: "Heuristic cannot limit stock", opts: SolveOptions{Strategy: StrategyHeuristic, Stock: map[int]int{5: 1}}, wantErr: ErrUnsupportedOption},
+		{name: "Cost needs prices", opts: SolveOptions{Strategy: StrategyCost}, wantErr: nil},
+		{name: "Unknown tie-break", opts: SolveOptions{TieBreak: "random"}, wantErr: ErrUnknownTieBreak},
+		{name: "Greedy cannot break ties", opts: SolveOptions{Strategy: StrategyGreedy, TieBreak: TieSmallerPacks}, wantErr: ErrUnsupportedOption},
+		{name: "No tie-break within stock", opts: SolveOptions{TieBreak: TieFewestSizes, Stock: map[int]int{5: 1}}, wantErr: ErrUnsupportedOption},
+		{name: "Default tie-break within stock", opts: SolveOptions{TieBreak: TieLargerPacks, Stock: map[int]int{5: 1}}, wantErr: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := packageSizes.Solve(12, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Solve() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := (Packages{Sizes: []int{5}}).Solve(12, SolveOptions{Strategy: StrategyCost}); !errors.Is(err, ErrMissingPrices) {
+		t.Errorf("Solve() error = %v, want %v", err, ErrMissingPrices)
+	}
+}
+
+// fixedStrategy always ships a single package of the largest size
+type fixedStrategy struct{}
+
+func (fixedStrategy) Name() string { return "fixed" }
+
+func (fixedStrategy) Pack(p Packages, amount int, opts SolveOptions) (Packing, error) {
+	largest := 0
+	for _, size := range p.Sizes {
+		largest = max(largest, size)
+	}
+	return NewPacking(amount, []int{largest}), nil
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	RegisterStrategy(fixedStrategy{})
+
+	if _, err := LookupStrategy("fixed"); err != nil {
+		t.Fatalf("LookupStrategy() error = %v", err)
+	}
+
+	order, err := Packages{Sizes: []int{5, 10}}.Solve(3, SolveOptions{Strategy: "fixed"})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(order.Result, []int{10}) {
+		t.Errorf("Solve() = %v, want %v", order.Result, []int{10})
+	}
+}
+
+// fullTablePacking solves amount with a table covering every total up to it,
+// without setting any packages aside
+func fullTablePacking(sizes, prices []int, amount int, objective Objective) Packing {
+	if objective != ObjectiveCost {
+		prices = nil
+	}
+
+	largest := 0
+	for _, size := range sizes {
+		largest = max(largest, size)
+	}
+
+	table, _ := newPackingTable(context.Background(), sizes, prices, amount+largest-1)
+	best := -1
+	for total := amount; total < amount+largest; total++ {
+		if table.reachable(total) && (best < 0 || (prices != nil && table.cost(total) < table.cost(best))) {
+			best = total
+		}
+	}
+
+	return newPackingFromCounts(amount, table.packages(best))
+}
+
+// bruteForcePacking tries every combination of packages that stops at the
+// first total covering amount and returns the best total and package count
+func bruteForcePacking(sizes []int, amount int) (int, int) {
+	bestTotal, bestCount := -1, -1
+	eachCover(sizes, nil, amount, func(counts []int) {
+		total, count := 0, 0
+		for i, n := range counts {
+			total += n * sizes[i]
+			count += n
+		}
+
+		if bestTotal < 0 || total < bestTotal || (total == bestTotal && count < bestCount) {
+			bestTotal, bestCount = total, count
+		}
+	})
+
+	return bestTotal, bestCount
+}
+
+// bruteForceCheapest tries every combination of packages that stops at the
+// first total covering amount and returns the lowest cost with its total and
+// package count
+func bruteForceCheapest(sizes, prices []int, amount int) (int, int, int) {
+	bestCost, bestTotal, bestCount := -1, -1, -1
+	eachCover(sizes, nil, amount, func(counts []int) {
+		cost, total, count := 0, 0, 0
+		for i, n := range counts {
+			cost += n * prices[i]
+			total += n * sizes[i]
+			count += n
+		}
+
+		if bestCost < 0 || cost < bestCost ||
+			(cost == bestCost && (total < bestTotal || (total == bestTotal && count < bestCount))) {
+			bestCost, bestTotal, bestCount = cost, total, count
+		}
+	})
+
+	return bestCost, bestTotal, bestCount
+}
+
+// bruteForceAlternatives tries every combination of packages without a spare
+// package and returns the overshoot and package count of each, best first
+func bruteForceAlternatives(sizes []int, amount int) [][2]int {
+	var found [][2]int
+
+	var try func(index, total, count, smallest int)
+	try = func(index, total, count, smallest int) {
+		if index == len(sizes) {
+			if total >= amount && total-smallest < amount {
+				found = append(found, [2]int{total - amount, count})
+			}
+			return
+		}
+
+		for n := 0; n == 0 || total+n*sizes[index] < amount+sizes[index]; n++ {
+			next := smallest
+			if n > 0 {
+				next = min(smallest, sizes[index])
+			}
+			try(index+1, total+n*sizes[index], count+n, next)
+		}
+	}
+	try(0, 0, 0, math.MaxInt)
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i][0] != found[j][0] {
+			return found[i][0] < found[j][0]
+		}
+		return found[i][1] < found[j][1]
+	})
+
+	return found
+}
+
+// eachCover calls fn with the count of every size for each combination of
+// packages whose total reaches amount without a package to spare at the end.
+// limits caps the count of each size and may be nil.
+func eachCover(sizes, limits []int, amount int, fn func(counts []int)) {
+	counts := make([]int, len(sizes))
+
+	var try func(index, total int)
+	try = func(index, total int) {
+		if total >= amount {
+			fn(counts)
+			return
+		}
+
+		if index == len(sizes) {
+			return
+		}
+
+		for n := 0; total+n*sizes[index] < amount+sizes[index] && (limits == nil || n <= limits[index]); n++ {
+			counts[index] = n
+			try(index+1, total+n*sizes[index])
+		}
+		counts[index] = 0
+	}
+	try(0, 0)
+}
+
+// randomSizes returns n distinct package sizes between 1 and largest
+func randomSizes(rng *rand.Rand, n, largest int) []int {
+	sizes := rng.Perm(largest)[:n]
+	for i := range sizes {
+		sizes[i]++
+	}
+	return sizes
+}
+
+// flatten lists every package in counts, smallest first
+func flatten(counts map[int]int) []int {
+	return newPackingFromCounts(0, counts).Flatten()
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func contains(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func BenchmarkSolve(b *testing.B) {
+	packageSizes := Packages{Sizes: []int{250, 500, 1000, 2000, 5000}}
+
+	for _, amount := range []int{1_000, 1_000_000, 1_000_000_000} {
+		b.Run(fmt.Sprintf("amount=%d", amount), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := packageSizes.Solve(amount, SolveOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSolveCost(b *testing.B) {
+	packageSizes := Packages{Sizes: []int{23, 31, 53}, Prices: []int{30, 38, 60}}
+
+	for _, amount := range []int{1_000, 1_000_000, 1_000_000_000} {
+		b.Run(fmt.Sprintf("amount=%d", amount), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := packageSizes.Solve(amount, SolveOptions{Objective: ObjectiveCost}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name       string
+		quantities []Quantity
+		want       Scale
+		wantWhole  []int
+		wantErr    error
+	}{
+		{
+			name:       "kilograms and grams",
+			quantities: []Quantity{{"2.5", MeasureKilogram}, {"500", MeasureGram}, {"1", MeasureKilogram}},
+			want:       Scale{Unit: MeasureKilogram, Precision: 1},
+			wantWhole:  []int{25, 5, 10},
+		},
+		{
+			name:       "grams and kilograms",
+			quantities: []Quantity{{"250", MeasureGram}, {"0.75", MeasureKilogram}},
+			want:       Scale{Unit: MeasureGram},
+			wantWhole:  []int{250, 750},
+		},
+		{
+			name:       "litres and millilitres",
+			quantities: []Quantity{{"1.25", MeasureLitre}, {"330", MeasureMillilitre}},
+			want:       Scale{Unit: MeasureLitre, Precision: 2},
+			wantWhole:  []int{125, 33},
+		},
+		{
+			name:       "weight and volume",
+			quantities: []Quantity{{"1", MeasureKilogram}, {"500", MeasureMillilitre}},
+			wantErr:    ErrInvalidQuantity,
+		},
+		{
+			name:       "unknown unit",
+			quantities: []Quantity{{"1", "lb"}},
+			wantErr:    ErrUnknownMeasure,
+		},
+		{
+			name:       "too precise",
+			quantities: []Quantity{{"0.0000001", MeasureKilogram}},
+			wantErr:    ErrInvalidQuantity,
+		},
+		{
+			name:       "not positive",
+			quantities: []Quantity{{"-2", MeasureGram}},
+			wantErr:    ErrInvalidQuantity,
+		},
+		{
+			name:       "exponent",
+			quantities: []Quantity{{"1e9", MeasureGram}},
+			wantErr:    ErrInvalidQuantity,
+		},
+		{
+			name:       "too large",
+			quantities: []Quantity{{"2000", MeasureKilogram}, {"0.001", MeasureGram}},
+			wantErr:    ErrAmountTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scale, err := NewScale(tt.quantities...)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("NewScale() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewScale() error = %v", err)
+			}
+
+			if scale != tt.want {
+				t.Errorf("NewScale() = %+v, want %+v", scale, tt.want)
+			}
+
+			for i, q := range tt.quantities {
+				whole, err := scale.Whole(q)
+				if err != nil || whole != tt.wantWhole[i] {
+					t.Errorf("Whole(%s) = %d, %v, want %d", q, whole, err, tt.wantWhole[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSolveQuantities(t *testing.T) {
+	scale := Scale{Unit: MeasureKilogram, Precision: 1}
+	packageSizes := Packages{Sizes: []int{5, 10}, Scale: &scale}
+
+	order, err := packageSizes.Solve(23, SolveOptions{})
+	if err != nil {
+		t.Fatalf("Solve() error = %v", err)
+	}
+
+	want := &Quantities{
+		Amount:    Quantity{"2.3", MeasureKilogram},
+		Items:     Quantity{"2.5", MeasureKilogram},
+		Overshoot: Quantity{"0.2", MeasureKilogram},
+	}
+	if !reflect.DeepEqual(order.Quantities, want) {
+		t.Errorf("Solve() quantities = %+v, want %+v", order.Quantities, want)
+	}
+
+	packageSizes.Scale = &Scale{Unit: MeasureKilogram, Precision: MaxPrecision + 1}
+	if _, err := packageSizes.Solve(23, SolveOptions{}); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("Solve() error = %v, want %v", err, ErrInvalidQuantity)
+	}
+}
+
+func TestSolveContext(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{23, 31, 53}}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := packageSizes.SolveContext(cancelled, 500000, SolveOptions{}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("SolveContext() error = %v, want %v", err, ErrCancelled)
+	}
+
+	if _, err := newPackingTable(cancelled, packageSizes.Sizes, nil, 4*checkInterval); !errors.Is(err, context.Canceled) {
+		t.Errorf("newPackingTable() error = %v, want %v", err, context.Canceled)
+	}
+
+	order, err := packageSizes.SolveContext(context.Background(), 500000, SolveOptions{Budget: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("SolveContext() error = %v", err)
+	}
+
+	if !order.Approximate || order.Packing.Items < 500000 {
+		t.Errorf("SolveContext() = %+v, want an approximate packing of at least 500000 items", order.Packing)
+	}
+
+	want, err := packageSizes.Solve(500000, SolveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err = packageSizes.SolveContext(context.Background(), 500000, SolveOptions{Budget: time.Minute})
+	if err != nil || !reflect.DeepEqual(order, want) {
+		t.Errorf("SolveContext() = %+v, %v, want %+v", order, err, want)
+	}
+
+	stock := SolveOptions{Budget: time.Nanosecond, Stock: map[int]int{53: 100}}
+	if _, err := packageSizes.SolveContext(context.Background(), 500000, stock); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("SolveContext() error = %v, want %v", err, ErrBudgetExceeded)
+	}
+}
+
+func TestPackingTableDeadlineWithManySizes(t *testing.T) {
+	// Each total tries every size, so the deadline must be checked per cell
+	sizes := make([]int, 20000)
+	for i := range sizes {
+		sizes[i] = i + 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := newPackingTable(ctx, sizes, nil, 1_000_000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("newPackingTable() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("newPackingTable() took %v with a deadline of 100ms", elapsed)
+	}
+}
+
+func TestCatalogueSolveContextBudget(t *testing.T) {
+	packageSizes := Packages{Sizes: []int{23, 31, 53}}
+	catalogue, err := CompileCatalogue(packageSizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := catalogue.SolveContext(context.Background(), 500000, SolveOptions{Budget: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("SolveContext() error = %v", err)
+	}
+	if !order.Approximate || order.Packing.Items < 500000 {
+		t.Errorf("SolveContext() = %+v, want an approximate packing of at least 500000 items", order.Packing)
+	}
+
+	// The interrupted table is built again by the next query
+	want, err := packageSizes.Solve(500000, SolveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, err := catalogue.Solve(500000, SolveOptions{}); err != nil || !reflect.DeepEqual(order, want) {
+		t.Errorf("Solve() = %+v, %v, want %+v", order, err, want)
+	}
+}
+
+// expiringStrategy packs like fixedStrategy, but uses up the budget doing so
+type expiringStrategy struct {
+	cancel context.CancelFunc
+}
+
+func (expiringStrategy) Name() string { return "expiring" }
+
+func (s expiringStrategy) Pack(p Packages, amount int, opts SolveOptions) (Packing, error) {
+	s.cancel()
+	return fixedStrategy{}.Pack(p, amount, opts)
+}
+
+func TestPackContextKeepsFinishedPacking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packing, err := packContext(ctx, expiringStrategy{cancel: cancel}, Packages{Sizes: []int{5, 10}}, 12, SolveOptions{})
+	if err != nil {
+		t.Fatalf("packContext() error = %v, want the finished packing", err)
+	}
+	if packing.Items != 10 {
+		t.Errorf("packContext() = %+v, want the fixed packing of 10 items", packing)
+	}
+}
+
+// countdownContext is done once its error has been checked checks times
+type countdownContext struct {
+	context.Context
+	checks int
+}
+
+func (c *countdownContext) Err() error {
+	if c.checks <= 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestSolveContextKeepsBestSoFar(t *testing.T) {
+	// The best packing of 150000 is 70000+90000. Filling from the largest
+	// size ships 100003+70000 instead.
+	packageSizes := Packages{Sizes: []int{70000, 90000, 100003}}
+
+	// Interrupted after 9*checkInterval cells, three per total, so past
+	// 160000 but short of the end of the window at 250002
+	ctx := &countdownContext{Context: context.Background(), checks: 9}
+	packing, err := exactStrategy{}.PackContext(ctx, packageSizes, 150000, SolveOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("PackContext() error = %v, want %v", err, context.Canceled)
+	}
+	if packing.Items != 160000 {
+		t.Errorf("PackContext() kept %+v, want 160000 items", packing)
+	}
+
+	tests := []struct {
+		name      string
+		found     Packing
+		wantItems int
+	}{
+		{"Best so far", packing, 160000},
+		{"Nothing found", Packing{}, 170003},
+		{"Fill is better", newPackingFromCounts(150000, map[int]int{90000: 2}), 170003},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := packageSizes.budgetOrder(150000, exactStrategy{}, SolveOptions{}, tt.found)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !order.Approximate || order.Packing.Items != tt.wantItems {
+				t.Errorf("budgetOrder() = %+v, want an approximate packing of %d items", order.Packing, tt.wantItems)
+			}
+		})
+	}
+}
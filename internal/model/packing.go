@@ -0,0 +1,66 @@
+package model
+
+import "sort"
+
+// PackCount is the number of packages of one size in a packing
+type PackCount struct {
+	Size  int `json:"size"`
+	Count int `json:"count"`
+}
+
+// Packing groups the packages of an order by size, largest size first, with
+// the totals a pick list needs
+type Packing struct {
+	Packs     []PackCount `json:"packs"`
+	Items     int         `json:"items"`
+	Overshoot int         `json:"overshoot"`
+	Packages  int         `json:"packages"`
+}
+
+// NewPacking groups the flat list of packages chosen for amount
+func NewPacking(amount int, packages []int) Packing {
+	counts := make(map[int]int)
+	for _, size := range packages {
+		counts[size]++
+	}
+
+	return newPackingFromCounts(amount, counts)
+}
+
+// newPackingFromCounts builds the packing from the number of packages of each size
+func newPackingFromCounts(amount int, counts map[int]int) Packing {
+	packing := Packing{Packs: []PackCount{}}
+	for size, count := range counts {
+		if count == 0 {
+			continue
+		}
+
+		packing.Packs = append(packing.Packs, PackCount{Size: size, Count: count})
+		packing.Items += size * count
+		packing.Packages += count
+	}
+	sort.Slice(packing.Packs, func(i, j int) bool { return packing.Packs[i].Size > packing.Packs[j].Size })
+
+	packing.Overshoot = packing.Items - amount
+	return packing
+}
+
+// Counts returns the number of packages of each size
+func (p Packing) Counts() map[int]int {
+	counts := make(map[int]int, len(p.Packs))
+	for _, pack := range p.Packs {
+		counts[pack.Size] = pack.Count
+	}
+	return counts
+}
+
+// Flatten expands the packing back into one entry per package, smallest first
+func (p Packing) Flatten() []int {
+	result := make([]int, 0, p.Packages)
+	for i := len(p.Packs) - 1; i >= 0; i-- {
+		for n := 0; n < p.Packs[i].Count; n++ {
+			result = append(result, p.Packs[i].Size)
+		}
+	}
+	return result
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// MaxPrecision is the most decimal places a Scale may keep
+	MaxPrecision = 6
+	// maxQuantityDigits is the longest quantity value accepted
+	maxQuantityDigits = 32
+)
+
+// Measure is a unit of measure for quantities sold by weight or volume
+type Measure string
+
+// The units of measure, by weight and by volume
+const (
+	MeasureGram       Measure = "g"
+	MeasureKilogram   Measure = "kg"
+	MeasureMillilitre Measure = "ml"
+	MeasureLitre      Measure = "l"
+)
+
+// measures gives the base unit of every known unit and the power of ten that
+// converts it to the base unit
+var measures = map[Measure]struct {
+	base     Measure
+	exponent int
+}{
+	MeasureGram:       {MeasureGram, 0},
+	MeasureKilogram:   {MeasureGram, 3},
+	MeasureMillilitre: {MeasureMillilitre, 0},
+	MeasureLitre:      {MeasureMillilitre, 3},
+}
+
+var (
+	// ErrUnknownMeasure is returned for a unit of measure the solver does not know
+	ErrUnknownMeasure = errors.New("unknown unit of measure")
+	// ErrInvalidQuantity is returned when a quantity is not a positive decimal,
+	// cannot be converted to the scale's unit or is not a whole number of its
+	// smallest step
+	ErrInvalidQuantity = errors.New("invalid quantity")
+)
+
+// Quantity is a decimal amount in a unit of measure, such as 2.5 kg
+type Quantity struct {
+	Value json.Number `json:"value"`
+	Unit  Measure     `json:"unit"`
+}
+
+// Scale declares how quantities become the whole numbers the solver packs:
+// each quantity is converted to Unit and counted in steps of 10^-Precision of
+// it. With a Scale of kg and precision 1, 2.5 kg and 500 g become 25 and 5.
+type Scale struct {
+	Unit      Measure `json:"unit"`
+	Precision int     `json:"precision"`
+}
+
+// Quantities is an order's amount, items shipped and overshoot in the unit
+// of its scale
+type Quantities struct {
+	Amount    Quantity `json:"amount"`
+	Items     Quantity `json:"items"`
+	Overshoot Quantity `json:"overshoot"`
+}
+
+// String formats the quantity as its value and unit, such as "2.5 kg"
+func (q Quantity) String() string {
+	return fmt.Sprintf("%s %s", q.Value, q.Unit)
+}
+
+// NewScale returns the scale in the unit of the first quantity with the
+// fewest decimal places that keep every quantity a whole number of steps
+func NewScale(quantities ...Quantity) (Scale, error) {
+	if len(quantities) == 0 {
+		return Scale{}, fmt.Errorf("%w: no quantities", ErrInvalidQuantity)
+	}
+
+	for precision := 0; precision <= MaxPrecision; precision++ {
+		s := Scale{Unit: quantities[0].Unit, Precision: precision}
+
+		whole := true
+		for _, q := range quantities {
+			_, err := s.Whole(q)
+			if err != nil && !errors.Is(err, errNotWhole) {
+				return Scale{}, err
+			}
+			whole = whole && err == nil
+		}
+
+		if whole {
+			return s, nil
+		}
+	}
+
+	return Scale{}, fmt.Errorf("%w: %v need more than %d decimal places", ErrInvalidQuantity, quantities, MaxPrecision)
+}
+
+// Validate checks that the scale's unit is known and its precision allowed
+func (s Scale) Validate() error {
+	if _, ok := measures[s.Unit]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownMeasure, s.Unit)
+	}
+
+	if s.Precision < 0 || s.Precision > MaxPrecision {
+		return fmt.Errorf("%w: precision %d is not between 0 and %d", ErrInvalidQuantity, s.Precision, MaxPrecision)
+	}
+
+	return nil
+}
+
+// errNotWhole is wrapped by Whole when a quantity falls between two steps
+var errNotWhole = fmt.Errorf("%w: not a whole number of steps", ErrInvalidQuantity)
+
+// Whole converts the quantity to a whole number of the scale's steps
+func (s Scale) Whole(q Quantity) (int, error) {
+	if err := s.Validate(); err != nil {
+		return 0, err
+	}
+
+	from, ok := measures[q.Unit]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownMeasure, q.Unit)
+	}
+
+	to := measures[s.Unit]
+	if from.base != to.base {
+		return 0, fmt.Errorf("%w: %s cannot be converted to %s", ErrInvalidQuantity, q, s.Unit)
+	}
+
+	// Plain decimals only, so a huge exponent cannot blow up the conversion
+	text := string(q.Value)
+	if len(text) > maxQuantityDigits || strings.ContainsAny(text, "eE") {
+		return 0, fmt.Errorf("%w: %s is not a plain decimal of up to %d digits", ErrInvalidQuantity, q, maxQuantityDigits)
+	}
+
+	value, ok := new(big.Rat).SetString(text)
+	if !ok || value.Sign() <= 0 {
+		return 0, fmt.Errorf("%w: %s is not a positive number", ErrInvalidQuantity, q)
+	}
+
+	value.Mul(value, pow10(from.exponent-to.exponent+s.Precision))
+	if !value.IsInt() {
+		return 0, fmt.Errorf("%w: %s in steps of %s", errNotWhole, q, s.step())
+	}
+
+	if !value.Num().IsInt64() || value.Num().Int64() > MaxAmount {
+		return 0, fmt.Errorf("%w: %s exceeds %d steps", ErrAmountTooLarge, q, MaxAmount)
+	}
+
+	return int(value.Num().Int64()), nil
+}
+
+// Quantity converts a whole number of the scale's steps back to a quantity
+// in the scale's unit
+func (s Scale) Quantity(n int) Quantity {
+	value := new(big.Rat).SetInt64(int64(n))
+	value.Mul(value, pow10(-s.Precision))
+
+	text := value.FloatString(s.Precision)
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+
+	return Quantity{Value: json.Number(text), Unit: s.Unit}
+}
+
+// quantities describes an order's amount, items and overshoot on the scale
+func (s Scale) quantities(amount int, packing Packing) *Quantities {
+	return &Quantities{
+		Amount:    s.Quantity(amount),
+		Items:     s.Quantity(packing.Items),
+		Overshoot: s.Quantity(packing.Overshoot),
+	}
+}
+
+// step returns the smallest quantity the scale can count
+func (s Scale) step() Quantity {
+	return s.Quantity(1)
+}
+
+// pow10 returns 10^exponent, which may be negative
+func pow10(exponent int) *big.Rat {
+	power := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponent))), nil)
+	if exponent < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), power)
+	}
+	return new(big.Rat).SetInt(power)
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,288 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+const (
+	// MaxRecommendSizes is the most package sizes Recommend proposes
+	MaxRecommendSizes = 10
+	// maxRecommendCandidates is the most candidate sizes Recommend tries
+	maxRecommendCandidates = 40
+	// maxRecommendAmounts is the most distinct order amounts Recommend scores
+	// a set of sizes against; the most common are kept and the savings are
+	// scaled up to every order
+	maxRecommendAmounts = 200
+	// maxRecommendRounds bounds the swaps Recommend tries after its first pick
+	maxRecommendRounds = 3
+	// maxRecommendSpan is the most totals the table scoring a set of sizes may
+	// hold. Sets that need more are skipped and counted in SkippedSets.
+	maxRecommendSpan = 1 << 16
+)
+
+var (
+	// ErrNoHistory is returned when there are no past orders to learn from
+	ErrNoHistory = errors.New("no order history")
+	// ErrInvalidRecommendation is returned when the recommendation options
+	// cannot be met
+	ErrInvalidRecommendation = errors.New("invalid recommendation options")
+)
+
+// RecommendOptions controls the package sizes Recommend proposes
+type RecommendOptions struct {
+	// Count is the number of sizes to propose
+	Count int `json:"count"`
+	// Include lists sizes the proposal must keep
+	Include []int `json:"include,omitempty"`
+	// Candidates lists the sizes to choose from. By default they are the
+	// current sizes, the included sizes and the most common order amounts.
+	Candidates []int `json:"candidates,omitempty"`
+	// PackageCost is how many items of overshoot one package is worth. At 0
+	// the overshoot comes first and the package count only breaks ties.
+	PackageCost int `json:"packageCost,omitempty"`
+}
+
+// SizeSetScore is how a set of package sizes would have packed past orders
+type SizeSetScore struct {
+	Sizes     []int `json:"sizes"`
+	Overshoot int   `json:"overshoot"`
+	Packages  int   `json:"packages"`
+}
+
+// Recommendation is a proposed set of package sizes and its projected savings
+// over the current set across past orders. Only the orders of the most common
+// amounts are scored: Recommended and Current are totals over the
+// ScoredOrders, and the savings are scaled from them to all Orders.
+// SkippedSets counts the sets of sizes that were too large to score,
+// including the current sizes when Current is missing.
+type Recommendation struct {
+	Orders         int           `json:"orders"`
+	ScoredOrders   int           `json:"scoredOrders"`
+	Recommended    SizeSetScore  `json:"recommended"`
+	Current        *SizeSetScore `json:"current,omitempty"`
+	OvershootSaved int           `json:"overshootSaved"`
+	PackagesSaved  int           `json:"packagesSaved"`
+	SkippedSets    int           `json:"skippedSets,omitempty"`
+}
+
+// demand is the number of past orders of each distinct amount
+type demand struct {
+	amounts []int
+	orders  []int
+}
+
+// Recommend proposes the opts.Count package sizes that would have packed the
+// past order amounts with the least total overshoot and then the fewest
+// packages, or the least of both when opts.PackageCost weighs them together.
+// The sizes are picked greedily and then improved by swapping one
+// size at a time, so the proposal is a good set rather than a proven best one.
+// current may be nil when there is no set to compare against.
+func Recommend(amounts, current []int, opts RecommendOptions) (Recommendation, error) {
+	if len(amounts) == 0 {
+		return Recommendation{}, ErrNoHistory
+	}
+
+	if opts.Count < 1 || opts.Count > MaxRecommendSizes || len(opts.Include) > opts.Count {
+		return Recommendation{}, fmt.Errorf("%w: %d sizes including %v, at most %d sizes",
+			ErrInvalidRecommendation, opts.Count, opts.Include, MaxRecommendSizes)
+	}
+
+	if opts.PackageCost < 0 || opts.PackageCost > MaxSize {
+		return Recommendation{}, fmt.Errorf("%w: package cost %d", ErrInvalidRecommendation, opts.PackageCost)
+	}
+
+	for _, sizes := range [][]int{opts.Include, opts.Candidates, current} {
+		if len(sizes) == 0 {
+			continue
+		}
+		if err := (Packages{Sizes: sizes}).Validate(); err != nil {
+			return Recommendation{}, err
+		}
+	}
+
+	for _, amount := range amounts {
+		if err := validateAmount(amount); err != nil {
+			return Recommendation{}, err
+		}
+	}
+
+	d := newDemand(amounts)
+	candidates := opts.Candidates
+	if len(candidates) == 0 {
+		candidates = defaultCandidates(d, current, opts.Include)
+	}
+
+	// score scores a set of sizes, counting the sets too large to score
+	skipped := 0
+	score := func(sizes []int) (SizeSetScore, bool) {
+		s, ok := d.score(sizes)
+		if !ok && len(sizes) > 0 {
+			skipped++
+		}
+		return s, ok
+	}
+
+	chosen := append([]int(nil), opts.Include...)
+	best, ok := score(chosen)
+
+	// Add the size that helps most until there are enough
+	for len(chosen) < opts.Count {
+		next, nextScore, found := -1, SizeSetScore{}, false
+		for _, c := range candidates {
+			if slices.Contains(chosen, c) {
+				continue
+			}
+
+			if s, ok := score(append(chosen, c)); ok && (!found || opts.better(s, nextScore)) {
+				next, nextScore, found = c, s, true
+			}
+		}
+
+		if !found {
+			break
+		}
+		chosen, best, ok = append(chosen, next), nextScore, true
+	}
+
+	if !ok {
+		return Recommendation{}, fmt.Errorf("%w: no candidate sizes pack the orders", ErrInvalidRecommendation)
+	}
+
+	// Swap sizes that are not required while that improves the score
+	for round := 0; round < maxRecommendRounds; round++ {
+		improved := false
+		for i, size := range chosen {
+			if slices.Contains(opts.Include, size) {
+				continue
+			}
+
+			for _, c := range candidates {
+				if slices.Contains(chosen, c) {
+					continue
+				}
+
+				trial := append([]int(nil), chosen...)
+				trial[i] = c
+				if s, ok := score(trial); ok && opts.better(s, best) {
+					chosen, best, improved = trial, s, true
+				}
+			}
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	r := Recommendation{Orders: len(amounts), ScoredOrders: d.scored(), Recommended: best}
+	if len(current) > 0 {
+		if s, ok := score(current); ok {
+			r.Current = &s
+			r.OvershootSaved = r.scale(s.Overshoot - best.Overshoot)
+			r.PackagesSaved = r.scale(s.Packages - best.Packages)
+		}
+	}
+	r.SkippedSets = skipped
+
+	return r, nil
+}
+
+// scale projects a total over the scored orders onto all orders
+func (r Recommendation) scale(total int) int {
+	if r.ScoredOrders == r.Orders {
+		return total
+	}
+	return int(int64(total) * int64(r.Orders) / int64(r.ScoredOrders))
+}
+
+// newDemand counts the orders of each amount, keeping the most common amounts
+func newDemand(amounts []int) demand {
+	counts := make(map[int]int)
+	for _, amount := range amounts {
+		counts[amount]++
+	}
+
+	d := demand{}
+	for amount := range counts {
+		d.amounts = append(d.amounts, amount)
+	}
+	sort.Slice(d.amounts, func(i, j int) bool {
+		a, b := d.amounts[i], d.amounts[j]
+		return counts[a] > counts[b] || (counts[a] == counts[b] && a < b)
+	})
+
+	d.amounts = d.amounts[:min(len(d.amounts), maxRecommendAmounts)]
+	for _, amount := range d.amounts {
+		d.orders = append(d.orders, counts[amount])
+	}
+
+	return d
+}
+
+// scored returns the number of orders of the amounts that are scored
+func (d demand) scored() int {
+	n := 0
+	for _, orders := range d.orders {
+		n += orders
+	}
+	return n
+}
+
+// defaultCandidates returns the current and included sizes and the most common
+// order amounts that can be package sizes
+func defaultCandidates(d demand, current, include []int) []int {
+	var candidates []int
+	add := func(size int) {
+		if size <= MaxSize && len(candidates) < maxRecommendCandidates && !slices.Contains(candidates, size) {
+			candidates = append(candidates, size)
+		}
+	}
+
+	for _, sizes := range [][]int{include, current, d.amounts} {
+		for _, size := range sizes {
+			add(size)
+		}
+	}
+
+	return candidates
+}
+
+// score packs every amount with the sizes, or returns false if they cannot
+// pack them all within maxRecommendSpan. Every amount is reduced as in
+// bestPacking, so one table serves them all.
+func (d demand) score(sizes []int) (SizeSetScore, bool) {
+	if len(sizes) == 0 {
+		return SizeSetScore{}, false
+	}
+
+	score := SizeSetScore{Sizes: append([]int(nil), sizes...)}
+	sort.Ints(score.Sizes)
+
+	w, err := newSharedWindow(context.Background(), score.Sizes, nil, d.amounts, ObjectivePackages, maxRecommendSpan)
+	if err != nil {
+		return SizeSetScore{}, false
+	}
+
+	for i, amount := range d.amounts {
+		w.seek(amount)
+		packing := newPackingFromCounts(amount, w.packages(w.best()))
+		score.Overshoot += d.orders[i] * packing.Overshoot
+		score.Packages += d.orders[i] * packing.Packages
+	}
+
+	return score, true
+}
+
+// better reports whether score a packs the orders better than b
+func (o RecommendOptions) better(a, b SizeSetScore) bool {
+	costA := a.Overshoot + o.PackageCost*a.Packages
+	costB := b.Overshoot + o.PackageCost*b.Packages
+	if costA != costB {
+		return costA < costB
+	}
+	return a.Packages < b.Packages
+}
@@ -0,0 +1,235 @@
+package model
+
+import (
+	"context"
+	"math/bits"
+	"sort"
+)
+
+// residueTable holds, for every residue of a total modulo the pivot, the best
+// packing of the sizes other than the pivot whose total falls in that residue
+// class. Adding pivot packages moves a packing along its class without
+// changing how it compares with the others in it, so once an amount is past
+// the totals of these packings, the best packing of any total is one of them
+// topped up with pivot packages. The table holds one entry per residue
+// however far apart the sizes are, which is what lets sizes such as 4999 and
+// 5000 pack amounts up to MaxAmount.
+//
+// The best packing in a class has the lowest weight when costs are
+// minimised, then the least spare, then the lowest total, which leaves the
+// most room for pivot packages. Without prices the pivot is the largest
+// size, so that matches TieLargerPacks; with prices it only matches it when
+// the pivot is also the largest size the tied packings differ in.
+type residueTable struct {
+	pivot      int
+	pivotPrice int
+	sizes      []int   // ascending, without the pivot
+	prices     []int   // price of each size, nil unless costs are minimised
+	weights    []wide  // pivot*price - pivotPrice*total, nil unless costs are minimised
+	spares     []int64 // pivot*packages - total
+	totals     []int64 // total of the packing, -1 if the residue cannot be reached
+	floor      int     // smallest amount the table covers
+}
+
+// residueLabel is how a packing of the other sizes compares with the rest of
+// its residue class. Labels add up along a packing, and every package adds a
+// positive label, so the best labels are shortest paths around the residues.
+type residueLabel struct {
+	weight wide
+	spare  int64
+	total  int64
+}
+
+// less reports whether label a is a better packing than b
+func (a residueLabel) less(b residueLabel) bool {
+	if a.weight != b.weight {
+		return a.weight.less(b.weight)
+	}
+	if a.spare != b.spare {
+		return a.spare < b.spare
+	}
+	return a.total < b.total
+}
+
+// newResidueTable finds the best packing of every residue class modulo the
+// pivot by going round each cycle of residues once per size (the round robin
+// of Böcker and Lipták, as in frobeniusNumber). It stops with the context's
+// error once the context is done.
+func newResidueTable(ctx context.Context, sizes, prices []int, r reduction) (*residueTable, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t := &residueTable{
+		pivot:  r.pivot,
+		spares: make([]int64, r.pivot),
+		totals: make([]int64, r.pivot),
+	}
+	if prices != nil {
+		t.weights = make([]wide, r.pivot)
+	}
+
+	order := make([]int, 0, len(sizes))
+	for i, size := range sizes {
+		if size == r.pivot {
+			if prices != nil {
+				t.pivotPrice = prices[i]
+			}
+			continue
+		}
+		order = append(order, i)
+	}
+	sort.Slice(order, func(a, b int) bool { return sizes[order[a]] < sizes[order[b]] })
+
+	for _, index := range order {
+		t.sizes = append(t.sizes, sizes[index])
+		if prices != nil {
+			t.prices = append(t.prices, prices[index])
+		}
+	}
+
+	for residue := 1; residue < t.pivot; residue++ {
+		t.totals[residue] = -1
+	}
+
+	cells := 0
+	for i, size := range t.sizes {
+		d := gcd(t.pivot, size)
+		for start := 0; start < d; start++ {
+			if err := interrupted(ctx, &cells, t.pivot/d); err != nil {
+				return nil, err
+			}
+
+			// Start the cycle through this class at its best packing
+			best := -1
+			for residue := start; residue < t.pivot; residue += d {
+				if t.totals[residue] >= 0 && (best < 0 || t.label(residue).less(t.label(best))) {
+					best = residue
+				}
+			}
+			if best < 0 {
+				continue
+			}
+
+			n := t.label(best)
+			for j := 0; j < t.pivot/d-1; j++ {
+				if err := interrupted(ctx, &cells, 1); err != nil {
+					return nil, err
+				}
+
+				n = t.add(n, i)
+				residue := int(n.total % int64(t.pivot))
+				if t.totals[residue] >= 0 && t.label(residue).less(n) {
+					n = t.label(residue)
+				}
+				t.set(residue, n)
+			}
+		}
+	}
+
+	// An amount covers a residue once the smallest total of that residue at
+	// or above it is at least the total of the class's best packing
+	for _, total := range t.totals {
+		t.floor = max(t.floor, int(total)-t.pivot+1)
+	}
+
+	return t, nil
+}
+
+// label returns the label of the best packing of the residue
+func (t *residueTable) label(residue int) residueLabel {
+	l := residueLabel{spare: t.spares[residue], total: t.totals[residue]}
+	if t.weights != nil {
+		l.weight = t.weights[residue]
+	}
+	return l
+}
+
+// set stores the label of the best packing of the residue
+func (t *residueTable) set(residue int, l residueLabel) {
+	t.spares[residue], t.totals[residue] = l.spare, l.total
+	if t.weights != nil {
+		t.weights[residue] = l.weight
+	}
+}
+
+// add returns the label of a packing with one more package of sizes[i]. Its
+// weight never goes down, as no size is cheaper per item than the pivot.
+func (t *residueTable) add(l residueLabel, i int) residueLabel {
+	size := t.sizes[i]
+	if t.prices != nil {
+		l.weight = l.weight.add(uint64(t.pivot*t.prices[i] - t.pivotPrice*size))
+	}
+	l.spare += int64(t.pivot - size)
+	l.total += int64(size)
+	return l
+}
+
+// bytes returns the memory held by the table's labels
+func (t *residueTable) bytes() int {
+	return 16*len(t.weights) + 8*len(t.spares) + 8*len(t.totals)
+}
+
+// covers reports whether the best packing of every total from amount on is
+// one of the table's packings topped up with pivot packages
+func (t *residueTable) covers(amount int) bool {
+	return amount >= t.floor
+}
+
+// reachable reports whether total can be made from the table's packing of
+// its residue and pivot packages
+func (t *residueTable) reachable(total int) bool {
+	base := t.totals[total%t.pivot]
+	return base >= 0 && base <= int64(total)
+}
+
+// cost returns the price of the packing of total times the pivot, which
+// keeps it whole
+func (t *residueTable) cost(total int) wide {
+	return t.weights[total%t.pivot].add(uint64(t.pivotPrice) * uint64(total))
+}
+
+// packages rebuilds the packing of total, taking the largest size at every
+// step, and tops it up with pivot packages
+func (t *residueTable) packages(total int) map[int]int {
+	counts := make(map[int]int)
+	residue := total % t.pivot
+	if pivots := (total - int(t.totals[residue])) / t.pivot; pivots > 0 {
+		counts[t.pivot] = pivots
+	}
+
+	for l := t.label(residue); l.total > 0; l = t.label(residue) {
+		for i := len(t.sizes) - 1; i >= 0; i-- {
+			prev := ((residue-t.sizes[i])%t.pivot + t.pivot) % t.pivot
+			if t.totals[prev] < 0 || t.add(t.label(prev), i) != l {
+				continue
+			}
+
+			counts[t.sizes[i]]++
+			residue = prev
+			break
+		}
+	}
+
+	return counts
+}
+
+// wide is an unsigned 128-bit integer, enough for a weight summed over up to
+// a pivot's worth of packages
+type wide struct {
+	hi, lo uint64
+}
+
+// add returns a+b
+func (a wide) add(b uint64) wide {
+	lo, carry := bits.Add64(a.lo, b, 0)
+	return wide{hi: a.hi + carry, lo: lo}
+}
+
+// less reports whether a is below b
+func (a wide) less(b wide) bool {
+	if a.hi != b.hi {
+		return a.hi < b.hi
+	}
+	return a.lo < b.lo
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MaxShipments is the most parcels an order may be split into
+const MaxShipments = 10_000
+
+var (
+	// ErrInvalidLimits is returned when a parcel limit is negative
+	ErrInvalidLimits = errors.New("invalid parcel limits")
+	// ErrMissingWeights is returned when parcels are limited by weight but the
+	// packages have no weights
+	ErrMissingWeights = errors.New("package weights are required")
+	// ErrPackageTooHeavy is returned when a single package is heavier than a
+	// parcel may be
+	ErrPackageTooHeavy = errors.New("package is heavier than a parcel allows")
+	// ErrTooManyShipments is returned when an order needs more than
+	// MaxShipments parcels
+	ErrTooManyShipments = errors.New("too many shipments")
+)
+
+// ParcelLimits caps what a courier accepts in one parcel. A zero limit means
+// no limit.
+type ParcelLimits struct {
+	MaxPackages int `json:"maxPackages,omitempty"`
+	MaxWeight   int `json:"maxWeight,omitempty"`
+}
+
+// Shipment is one parcel of an order, with its packages grouped by size,
+// largest size first
+type Shipment struct {
+	Packs    []PackCount `json:"packs"`
+	Packages int         `json:"packages"`
+	Items    int         `json:"items"`
+	Weight   int         `json:"weight,omitempty"`
+}
+
+// Validate checks that the limits are not negative
+func (l ParcelLimits) Validate() error {
+	if l.MaxPackages < 0 || l.MaxWeight < 0 {
+		return fmt.Errorf("%w: %+v", ErrInvalidLimits, l)
+	}
+	return nil
+}
+
+// ValidateShipping checks that parcels can be limited as asked with p
+func (p Packages) ValidateShipping(limits ParcelLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	if limits.MaxWeight > 0 && len(p.Weights) == 0 {
+		return ErrMissingWeights
+	}
+
+	return nil
+}
+
+// Ship splits the packing into parcels within the limits. Packages are placed
+// heaviest first into the first parcel with room (first fit decreasing),
+// which keeps the number of parcels low and is optimal when only the package
+// count is limited.
+func (p Packages) Ship(packing Packing, limits ParcelLimits) ([]Shipment, error) {
+	if err := p.ValidateShipping(limits); err != nil {
+		return nil, err
+	}
+
+	weights := make(map[int]int, len(p.Weights))
+	for i, weight := range p.Weights {
+		weights[p.Sizes[i]] = weight
+	}
+
+	packs := append([]PackCount(nil), packing.Packs...)
+	sort.SliceStable(packs, func(i, j int) bool { return weights[packs[i].Size] > weights[packs[j].Size] })
+
+	totalWeight := 0
+	for _, pack := range packs {
+		weight := weights[pack.Size]
+		if limits.MaxWeight > 0 && weight > limits.MaxWeight {
+			return nil, fmt.Errorf("%w: size %d weighs %d, the limit is %d",
+				ErrPackageTooHeavy, pack.Size, weight, limits.MaxWeight)
+		}
+		totalWeight += pack.Count * weight
+	}
+
+	// No packing fits in fewer parcels than either limit allows
+	fewest := 0
+	if limits.MaxPackages > 0 {
+		fewest = (packing.Packages + limits.MaxPackages - 1) / limits.MaxPackages
+	}
+	if limits.MaxWeight > 0 {
+		fewest = max(fewest, (totalWeight+limits.MaxWeight-1)/limits.MaxWeight)
+	}
+	if fewest > MaxShipments {
+		return nil, fmt.Errorf("%w: at least %d parcels, the limit is %d", ErrTooManyShipments, fewest, MaxShipments)
+	}
+
+	var shipments []Shipment
+	for _, pack := range packs {
+		weight := weights[pack.Size]
+		remaining := pack.Count
+
+		for i := 0; remaining > 0; i++ {
+			if i == len(shipments) {
+				if len(shipments) == MaxShipments {
+					return nil, fmt.Errorf("%w: the limit is %d", ErrTooManyShipments, MaxShipments)
+				}
+				shipments = append(shipments, Shipment{})
+			}
+
+			n := limits.room(shipments[i], weight, remaining)
+			if n == 0 {
+				continue
+			}
+
+			shipment := &shipments[i]
+			shipment.Packs = append(shipment.Packs, PackCount{Size: pack.Size, Count: n})
+			shipment.Packages += n
+			shipment.Items += n * pack.Size
+			shipment.Weight += n * weight
+			remaining -= n
+		}
+	}
+
+	for _, shipment := range shipments {
+		sort.Slice(shipment.Packs, func(i, j int) bool { return shipment.Packs[i].Size > shipment.Packs[j].Size })
+	}
+
+	return shipments, nil
+}
+
+// room returns how many of up to count packages of the given weight still fit
+// in the shipment
+func (l ParcelLimits) room(shipment Shipment, weight, count int) int {
+	if l.MaxPackages > 0 {
+		count = min(count, l.MaxPackages-shipment.Packages)
+	}
+
+	if l.MaxWeight > 0 && weight > 0 {
+		count = min(count, (l.MaxWeight-shipment.Weight)/weight)
+	}
+
+	return max(0, count)
+}
@@ -0,0 +1,530 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Objective selects what the solver minimises
+type Objective string
+
+const (
+	// ObjectivePackages ships the fewest items, then uses the fewest packages
+	ObjectivePackages Objective = "packages"
+	// ObjectiveCost minimises the total price, then the items shipped, then
+	// the number of packages
+	ObjectiveCost Objective = "cost"
+)
+
+// maxTableSpan is the most totals a packing table may hold. Each total costs
+// four bytes, or twelve when minimising cost.
+const maxTableSpan = 1 << 24
+
+// checkInterval is how many cells, a total tried with one size or bundle,
+// the solver loops fill between checks for cancellation
+const checkInterval = 1 << 16
+
+// SolveOptions controls how an order is packed
+type SolveOptions struct {
+	Objective Objective `json:"objective,omitempty"`
+	// Strategy names the registered PackingStrategy to use. See StrategyName
+	// for the default.
+	Strategy string `json:"strategy,omitempty"`
+	// Stock limits how many packages of each size may be used. Sizes that are
+	// not listed are unlimited, and a nil map means unlimited stock.
+	Stock map[int]int `json:"stock,omitempty"`
+	// TieBreak chooses between equally good packings. It defaults to
+	// TieLargerPacks.
+	TieBreak TieBreak `json:"tieBreak,omitempty"`
+	// Budget limits how long SolveContext searches before falling back to the
+	// best packing found so far. Zero means no limit.
+	Budget time.Duration `json:"-"`
+}
+
+// packingTable holds the best way to reach every exact total from 0 up to its
+// span: the fewest packages, or for ObjectiveCost the lowest price and then
+// the fewest packages. Unreachable totals hold a count of -1.
+type packingTable struct {
+	sizes  []int   // ascending
+	prices []int   // price of each size, nil unless costs are minimised
+	counts []int32 // fewest packages per total
+	costs  []int64 // lowest price per total, nil unless costs are minimised
+}
+
+// newPackingTable builds the table for the given package sizes. prices may be
+// nil, in which case only the package count matters. It stops with the
+// context's error once the context is done, returning the table of the
+// totals it had built so far.
+func newPackingTable(ctx context.Context, sizes, prices []int, span int) (*packingTable, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return sizes[order[a]] < sizes[order[b]] })
+
+	t := &packingTable{
+		sizes:  make([]int, len(sizes)),
+		counts: make([]int32, span+1),
+	}
+	if prices != nil {
+		t.prices = make([]int, len(sizes))
+		t.costs = make([]int64, span+1)
+	}
+	for i, index := range order {
+		t.sizes[i] = sizes[index]
+		if prices != nil {
+			t.prices[i] = prices[index]
+		}
+	}
+
+	cells := 0
+	for total := 1; total <= span; total++ {
+		if err := interrupted(ctx, &cells, len(t.sizes)); err != nil {
+			t.counts = t.counts[:total]
+			if t.costs != nil {
+				t.costs = t.costs[:total]
+			}
+			return t, err
+		}
+
+		t.counts[total] = -1
+		for i, size := range t.sizes {
+			if size > total {
+				break
+			}
+
+			prev := total - size
+			if t.counts[prev] < 0 {
+				continue
+			}
+
+			count := t.counts[prev] + 1
+			if t.costs == nil {
+				if t.counts[total] < 0 || count < t.counts[total] {
+					t.counts[total] = count
+				}
+				continue
+			}
+
+			cost := t.costs[prev] + int64(t.prices[i])
+			if t.counts[total] < 0 || cost < t.costs[total] || (cost == t.costs[total] && count < t.counts[total]) {
+				t.counts[total], t.costs[total] = count, cost
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// interrupted adds n cells to the count of those filled since the last
+// check, and returns the context's error once there are checkInterval of them
+func interrupted(ctx context.Context, cells *int, n int) error {
+	*cells += n
+	if *cells < checkInterval {
+		return nil
+	}
+
+	*cells = 0
+	return ctx.Err()
+}
+
+// bytes returns the memory held by the table's counts and costs
+func (t *packingTable) bytes() int {
+	return 4*len(t.counts) + 8*len(t.costs)
+}
+
+// reachable reports whether total can be made from whole packages
+func (t *packingTable) reachable(total int) bool {
+	return total >= 0 && total < len(t.counts) && t.counts[total] >= 0
+}
+
+// cost returns the lowest price of reaching total
+func (t *packingTable) cost(total int) int64 {
+	if t.costs == nil {
+		return 0
+	}
+	return t.costs[total]
+}
+
+// packages rebuilds the best packages that add up to total, taking the
+// largest size at every step, and returns how many of each size are used
+func (t *packingTable) packages(total int) map[int]int {
+	counts := make(map[int]int)
+	for total > 0 {
+		for i := len(t.sizes) - 1; i >= 0; i-- {
+			size := t.sizes[i]
+			if size > total {
+				continue
+			}
+
+			prev := total - size
+			if t.counts[prev] != t.counts[total]-1 {
+				continue
+			}
+
+			if t.costs != nil && t.costs[prev] != t.costs[total]-int64(t.prices[i]) {
+				continue
+			}
+
+			counts[size]++
+			total = prev
+			break
+		}
+	}
+
+	return counts
+}
+
+// reduction sets aside packages of one pivot size before the table is built,
+// so the table only has to cover a window of totals whatever the amount
+type reduction struct {
+	pivot int // package size set aside
+	bound int // largest total the other sizes reach in any optimal packing
+}
+
+// newReduction picks the pivot for the objective and bounds how much the
+// other sizes can contribute to an optimal packing.
+//
+// The pivot is the largest size, or for ObjectiveCost the largest of the
+// sizes with the lowest price per item. Any pivot packages' worth of other
+// packages contains a group whose total is a multiple of the pivot (by the
+// pigeonhole principle on the running totals), and swapping that group for
+// pivot packages ships the same items with fewer packages or a lower price.
+// So an optimal packing uses fewer than pivot other packages, and fewer than
+// pivot/gcd(size, pivot) of each other size.
+func newReduction(sizes, prices []int, objective Objective) reduction {
+	pivot, pivotIndex := 0, -1
+	for i, size := range sizes {
+		if pivotIndex < 0 {
+			pivot, pivotIndex = size, i
+			continue
+		}
+
+		if objective == ObjectiveCost {
+			// Compare price per item without dividing
+			lhs, rhs := prices[i]*pivot, prices[pivotIndex]*size
+			if lhs < rhs || (lhs == rhs && size > pivot) {
+				pivot, pivotIndex = size, i
+			}
+			continue
+		}
+
+		if size > pivot {
+			pivot, pivotIndex = size, i
+		}
+	}
+
+	byGCD, largestOther := 0, 0
+	for i, size := range sizes {
+		if i == pivotIndex {
+			continue
+		}
+
+		byGCD += (pivot/gcd(size, pivot) - 1) * size
+		largestOther = max(largestOther, size)
+	}
+
+	return reduction{pivot: pivot, bound: min(byGCD, (pivot-1)*largestOther)}
+}
+
+// setAside returns how many pivot packages every optimal packing of amount
+// is guaranteed to contain
+func (r reduction) setAside(amount int) int {
+	if amount <= r.bound {
+		return 0
+	}
+	return (amount - r.bound) / r.pivot
+}
+
+// packingWindow is the packing table for the totals that could hold the best
+// packing of an amount, once the pivot packages are set aside. One table can
+// serve many amounts by seeking to each in turn. When the table would be too
+// large, the window searches a residueTable instead.
+type packingWindow struct {
+	table      *packingTable
+	residues   *residueTable // replaces table when the sizes are too far apart
+	reduction  reduction
+	largest    int // largest package size
+	pivot      int // size of the packages set aside
+	pivotPrice int // price of each package set aside
+	aside      int // number of packages set aside
+	from, to   int // totals of the table to search
+	tie        TieBreak
+}
+
+// newPackingWindow builds the table for amount. Every total that could be
+// optimal is searched: a packing reaching amount+largest or more always has a
+// package that can be dropped while still covering the order, which never
+// adds items, packages or cost. When ctx is done first, the window is
+// returned with the error and only holds the totals built so far.
+func newPackingWindow(ctx context.Context, sizes, prices []int, amount int, objective Objective) (*packingWindow, error) {
+	w, err := newSharedWindow(ctx, sizes, prices, []int{amount}, objective, maxTableSpan)
+	if w != nil {
+		w.seek(amount)
+	}
+
+	return w, err
+}
+
+// newSharedWindow builds one table that covers the windows of all the
+// amounts, as long as it needs no more than limit totals. Past that it
+// builds a residueTable of one entry per residue of the pivot instead, if the
+// pivot is within limit and the table covers every amount. Call seek before
+// searching it. When ctx is done first, the window is returned with the
+// error and only holds the totals built so far, or is nil for a residueTable.
+func newSharedWindow(ctx context.Context, sizes, prices, amounts []int, objective Objective, limit int) (*packingWindow, error) {
+	if objective != ObjectiveCost {
+		prices = nil
+	}
+
+	r := newReduction(sizes, prices, objective)
+	w := &packingWindow{reduction: r, pivot: r.pivot}
+	for i, size := range sizes {
+		w.largest = max(w.largest, size)
+		if size == r.pivot && prices != nil {
+			w.pivotPrice = prices[i]
+		}
+	}
+
+	span, widest := 0, 0
+	for _, amount := range amounts {
+		if reach := amount - r.setAside(amount)*r.pivot + w.largest - 1; reach > span {
+			span, widest = reach, amount
+		}
+	}
+
+	if span > limit {
+		return w.fromResidues(ctx, sizes, prices, amounts, limit, span, widest)
+	}
+
+	table, err := newPackingTable(ctx, sizes, prices, span)
+	if table == nil {
+		return nil, err
+	}
+
+	w.table = table
+	return w, err
+}
+
+// fromResidues builds the residueTable for sizes whose table would need span
+// totals, or returns ErrCatalogueTooWide if it cannot pack every amount
+func (w *packingWindow) fromResidues(ctx context.Context, sizes, prices, amounts []int, limit, span, widest int) (*packingWindow, error) {
+	tooWide := fmt.Errorf("%w: sizes %v need a table of %d totals to pack %d, the limit is %d",
+		ErrCatalogueTooWide, sizes, span, widest, limit)
+	if w.pivot > limit {
+		return nil, tooWide
+	}
+
+	residues, err := newResidueTable(ctx, sizes, prices, w.reduction)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, amount := range amounts {
+		if !residues.covers(amount) {
+			return nil, tooWide
+		}
+	}
+
+	w.residues = residues
+	return w, nil
+}
+
+// covers reports whether the window can seek to amount
+func (w *packingWindow) covers(amount int) bool {
+	if w.residues != nil {
+		return w.residues.covers(amount)
+	}
+	return amount-w.reduction.setAside(amount)*w.pivot+w.largest-1 < len(w.table.counts)
+}
+
+// supports returns ErrCatalogueTooWide if the window cannot break ties by tie.
+// A residueTable only ever breaks them by TieLargerPacks.
+func (w *packingWindow) supports(tie TieBreak) error {
+	if w.residues == nil || tie.isDefault() {
+		return nil
+	}
+	return fmt.Errorf("%w: ties between packings of sizes this wide can only be broken by %s, not %s",
+		ErrCatalogueTooWide, TieLargerPacks, tie)
+}
+
+// bytes returns the memory held by the window's table
+func (w *packingWindow) bytes() int {
+	if w.residues != nil {
+		return w.residues.bytes()
+	}
+	return w.table.bytes()
+}
+
+// seek moves the window to the totals that could hold the best packing of
+// amount. The table must cover them. A residueTable holds no pivot packages
+// to set aside, and only needs one total per residue.
+func (w *packingWindow) seek(amount int) {
+	if w.residues != nil {
+		w.aside, w.from, w.to = 0, amount, amount+w.pivot-1
+		return
+	}
+
+	w.aside = w.reduction.setAside(amount)
+	w.from = amount - w.aside*w.pivot
+	w.to = w.from + w.largest - 1
+}
+
+// reachable reports whether the packing of total can be rebuilt
+func (w *packingWindow) reachable(total int) bool {
+	if w.residues != nil {
+		return w.residues.reachable(total)
+	}
+	return w.table.reachable(total)
+}
+
+// priced reports whether the window minimises cost
+func (w *packingWindow) priced() bool {
+	if w.residues != nil {
+		return w.residues.weights != nil
+	}
+	return w.table.costs != nil
+}
+
+// better reports whether the table total a makes a better packing than b:
+// a lower price when costs are minimised, then fewer items. Each total
+// already holds its fewest packages.
+func (w *packingWindow) better(a, b int) bool {
+	if w.residues != nil && w.priced() && w.residues.cost(a) != w.residues.cost(b) {
+		return w.residues.cost(a).less(w.residues.cost(b))
+	}
+	if w.table != nil && w.priced() && w.table.cost(a) != w.table.cost(b) {
+		return w.table.cost(a) < w.table.cost(b)
+	}
+	return a < b
+}
+
+// best returns the table total holding the best packing
+func (w *packingWindow) best() int {
+	best := -1
+	for total := w.from; total <= w.to; total++ {
+		if !w.reachable(total) {
+			continue
+		}
+
+		// Without prices the first reachable total ships the fewest items
+		if !w.priced() {
+			return total
+		}
+
+		if best < 0 || w.better(total, best) {
+			best = total
+		}
+	}
+
+	return best
+}
+
+// partial returns the best packing of amount among the totals of the window
+// built before its table was interrupted, or an empty Packing if none of
+// them can be reached
+func (w *packingWindow) partial(amount int, tie TieBreak) Packing {
+	if w == nil {
+		return Packing{}
+	}
+
+	w.tie = tie
+	best := w.best()
+	if best < 0 {
+		return Packing{}
+	}
+
+	return newPackingFromCounts(amount, w.packages(best))
+}
+
+// packages returns how many packages of each size make the packing of the
+// table total, including the packages set aside, breaking ties by w.tie
+func (w *packingWindow) packages(total int) map[int]int {
+	if w.residues != nil {
+		return w.residues.packages(total)
+	}
+
+	forced := 0
+	if w.aside > 0 {
+		forced = w.pivot
+	}
+
+	counts := w.table.packagesBy(total, w.tie, forced)
+	if w.aside > 0 {
+		counts[w.pivot] += w.aside
+	}
+	return counts
+}
+
+// bestPacking returns how many packages of each size make the best packing of
+// amount under the objective
+func bestPacking(sizes, prices []int, amount int, objective Objective) (map[int]int, error) {
+	w, err := newPackingWindow(context.Background(), sizes, prices, amount, objective)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.packages(w.best()), nil
+}
+
+// exactPacking returns how many packages of each size make up exactly total
+// with the fewest packages, or false if total cannot be made
+func exactPacking(sizes []int, total int) (map[int]int, bool, error) {
+	r := newReduction(sizes, nil, ObjectivePackages)
+	aside := r.setAside(total)
+	if total-aside*r.pivot > maxTableSpan {
+		return exactFromResidues(sizes, total, r)
+	}
+	total -= aside * r.pivot
+
+	table, err := newPackingTable(context.Background(), sizes, nil, total)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !table.reachable(total) {
+		return nil, false, nil
+	}
+
+	counts := table.packages(total)
+	if aside > 0 {
+		counts[r.pivot] += aside
+	}
+
+	return counts, true, nil
+}
+
+// exactFromResidues is exactPacking for sizes too wide for a table. It
+// returns ErrCatalogueTooWide when total is below the packing of its residue,
+// as a packing with fewer pivot packages could still reach it.
+func exactFromResidues(sizes []int, total int, r reduction) (map[int]int, bool, error) {
+	residues, err := newResidueTable(context.Background(), sizes, nil, r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	base := residues.totals[total%r.pivot]
+	switch {
+	case base < 0:
+		return nil, false, nil
+	case base > int64(total):
+		return nil, false, fmt.Errorf("%w: sizes %v cannot make %d exactly below %d",
+			ErrCatalogueTooWide, sizes, total, residues.floor)
+	}
+
+	return residues.packages(total), true, nil
+}
+
+// gcd returns the greatest common divisor of a and b
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
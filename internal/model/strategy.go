@@ -0,0 +1,262 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// StrategyGreedy fills from the largest size, tops up with one package and
+	// then repacks that total with the fewest packages. It may ship more items
+	// than needed.
+	StrategyGreedy = "greedy"
+	// StrategyExact finds the packing that is optimal for the objective
+	StrategyExact = "exact"
+	// StrategyCost finds the cheapest packing
+	StrategyCost = "cost"
+	// StrategyHeuristic fills with the largest size and only solves the last
+	// two largest packages' worth exactly. Its running time depends only on
+	// the largest size, but it may ship more items than needed.
+	StrategyHeuristic = "heuristic"
+)
+
+var (
+	// ErrUnknownStrategy is returned for a strategy that is not registered
+	ErrUnknownStrategy = errors.New("unknown strategy")
+	// ErrUnsupportedOption is returned when a strategy cannot honour an option
+	ErrUnsupportedOption = errors.New("option not supported by strategy")
+)
+
+// PackingStrategy packs an order amount with a set of package sizes. The
+// inputs are validated before Pack is called.
+type PackingStrategy interface {
+	Name() string
+	Pack(p Packages, amount int, opts SolveOptions) (Packing, error)
+}
+
+// OptionChecker is implemented by strategies that cannot honour every
+// option, so that orders can be rejected before any work is done
+type OptionChecker interface {
+	Supports(p Packages, opts SolveOptions) error
+}
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = make(map[string]PackingStrategy)
+)
+
+func init() {
+	RegisterStrategy(greedyStrategy{})
+	RegisterStrategy(exactStrategy{})
+	RegisterStrategy(costStrategy{})
+	RegisterStrategy(heuristicStrategy{})
+}
+
+// RegisterStrategy makes a strategy available by its name, replacing any
+// strategy already registered under that name
+func RegisterStrategy(strategy PackingStrategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	strategies[strategy.Name()] = strategy
+}
+
+// LookupStrategy returns the strategy registered under name
+func LookupStrategy(name string) (PackingStrategy, error) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	strategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, name)
+	}
+
+	return strategy, nil
+}
+
+// Strategies returns the names of all registered strategies in order
+func Strategies() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// StrategyName returns the strategy the options select. Without an explicit
+// strategy, cost objectives use StrategyCost and everything else StrategyExact.
+func (o SolveOptions) StrategyName() string {
+	switch {
+	case o.Strategy != "":
+		return o.Strategy
+	case o.Objective == ObjectiveCost:
+		return StrategyCost
+	default:
+		return StrategyExact
+	}
+}
+
+// exactStrategy finds the optimal packing for the objective, within the
+// stock when it is limited
+type exactStrategy struct{}
+
+func (exactStrategy) Name() string { return StrategyExact }
+
+func (exactStrategy) Supports(p Packages, opts SolveOptions) error {
+	if opts.Stock != nil && !opts.TieBreak.isDefault() {
+		return fmt.Errorf("%w: ties cannot be broken by %s within limited stock", ErrUnsupportedOption, opts.TieBreak)
+	}
+	return nil
+}
+
+func (s exactStrategy) Pack(p Packages, amount int, opts SolveOptions) (Packing, error) {
+	return s.PackContext(context.Background(), p, amount, opts)
+}
+
+func (exactStrategy) PackContext(ctx context.Context, p Packages, amount int, opts SolveOptions) (Packing, error) {
+	if opts.Stock != nil {
+		counts, err := boundedPacking(ctx, p.Sizes, p.Prices, opts.Stock, amount, opts.Objective)
+		if err != nil {
+			return Packing{}, err
+		}
+
+		return newPackingFromCounts(amount, counts), nil
+	}
+
+	w, err := newPackingWindow(ctx, p.Sizes, p.Prices, amount, opts.Objective)
+	if err != nil {
+		return w.partial(amount, opts.TieBreak), err
+	}
+
+	if err := w.supports(opts.TieBreak); err != nil {
+		return Packing{}, err
+	}
+
+	w.tie = opts.TieBreak
+	return newPackingFromCounts(amount, w.packages(w.best())), nil
+}
+
+// costStrategy finds the cheapest packing whatever the objective says
+type costStrategy struct{}
+
+func (costStrategy) Name() string { return StrategyCost }
+
+func (costStrategy) Supports(p Packages, opts SolveOptions) error {
+	if len(p.Prices) == 0 {
+		return ErrMissingPrices
+	}
+	return exactStrategy{}.Supports(p, opts)
+}
+
+func (s costStrategy) Pack(p Packages, amount int, opts SolveOptions) (Packing, error) {
+	return s.PackContext(context.Background(), p, amount, opts)
+}
+
+func (costStrategy) PackContext(ctx context.Context, p Packages, amount int, opts SolveOptions) (Packing, error) {
+	opts.Objective = ObjectiveCost
+	return exactStrategy{}.PackContext(ctx, p, amount, opts)
+}
+
+// greedyStrategy is the original packing algorithm: fill from the largest
+// size, top up with the smallest size that covers the rest, then repack that
+// total with the fewest packages
+type greedyStrategy struct{}
+
+func (greedyStrategy) Name() string { return StrategyGreedy }
+
+func (greedyStrategy) Supports(p Packages, opts SolveOptions) error {
+	return unsupported(StrategyGreedy, opts)
+}
+
+func (greedyStrategy) Pack(p Packages, amount int, opts SolveOptions) (Packing, error) {
+	sizes := append([]int(nil), p.Sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	total, remaining := 0, amount
+	for _, size := range sizes {
+		n := remaining / size
+		total += n * size
+		remaining -= n * size
+	}
+
+	if remaining > 0 {
+		for i := len(sizes) - 1; i >= 0; i-- {
+			if remaining <= sizes[i] {
+				total += sizes[i]
+				break
+			}
+		}
+	}
+
+	counts, ok, err := exactPacking(sizes, total)
+	if err != nil {
+		return Packing{}, err
+	}
+
+	if !ok {
+		return Packing{}, fmt.Errorf("total %d cannot be made from sizes %v", total, sizes)
+	}
+
+	return newPackingFromCounts(amount, counts), nil
+}
+
+// heuristicStrategy fills with the largest size and solves the remainder
+// exactly, so the table never holds more than three largest packages' worth
+// of totals
+type heuristicStrategy struct{}
+
+func (heuristicStrategy) Name() string { return StrategyHeuristic }
+
+func (heuristicStrategy) Supports(p Packages, opts SolveOptions) error {
+	return unsupported(StrategyHeuristic, opts)
+}
+
+func (heuristicStrategy) Pack(p Packages, amount int, opts SolveOptions) (Packing, error) {
+	largest := 0
+	for _, size := range p.Sizes {
+		largest = max(largest, size)
+	}
+
+	aside := max(0, amount/largest-1)
+	remainder := amount - aside*largest
+
+	table, err := newPackingTable(context.Background(), p.Sizes, nil, remainder+largest-1)
+	if err != nil {
+		return Packing{}, err
+	}
+
+	best := remainder
+	for !table.reachable(best) {
+		best++
+	}
+
+	counts := table.packages(best)
+	counts[largest] += aside
+
+	return newPackingFromCounts(amount, counts), nil
+}
+
+// unsupported rejects the options the simple strategies cannot honour
+func unsupported(strategy string, opts SolveOptions) error {
+	if opts.Objective == ObjectiveCost {
+		return fmt.Errorf("%w: %s cannot minimise cost", ErrUnsupportedOption, strategy)
+	}
+
+	if opts.Stock != nil {
+		return fmt.Errorf("%w: %s cannot limit stock", ErrUnsupportedOption, strategy)
+	}
+
+	if !opts.TieBreak.isDefault() {
+		return fmt.Errorf("%w: %s cannot break ties by %s", ErrUnsupportedOption, strategy, opts.TieBreak)
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TieBreak chooses between packings that are equally good under the
+// objective: the same items, the same number of packages and, when costs are
+// minimised, the same cost
+type TieBreak string
+
+const (
+	// TieLargerPacks prefers the packing with more of the largest size that
+	// differs, so 9+9+6+4 beats 8+8+6+6. It is the default.
+	TieLargerPacks TieBreak = "largerPacks"
+	// TieSmallerPacks prefers the packing with fewer of the largest size that
+	// differs, so its largest pack is as small as possible
+	TieSmallerPacks TieBreak = "smallerPacks"
+	// TieFewestSizes prefers the packing with the fewest distinct sizes, then
+	// larger packs
+	TieFewestSizes TieBreak = "fewestSizes"
+	// TieLexicographic prefers the packing whose packages, listed smallest
+	// first as in Order.Result, come first in lexicographic order
+	TieLexicographic TieBreak = "lexicographic"
+)
+
+// maxTieBreakCells bounds the work of finding the packing with the fewest
+// distinct sizes. Past it, the best packing found so far is kept, or the one
+// with larger packs if none was found.
+const maxTieBreakCells = 1 << 26
+
+// ErrUnknownTieBreak is returned for a tie-break policy the solver does not know
+var ErrUnknownTieBreak = errors.New("unknown tie-break policy")
+
+// validateTieBreak checks that the tie-break policy is known
+func validateTieBreak(tie TieBreak) error {
+	switch tie {
+	case "", TieLargerPacks, TieSmallerPacks, TieFewestSizes, TieLexicographic:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownTieBreak, tie)
+	}
+}
+
+// isDefault reports whether the policy is the one every strategy follows
+func (tie TieBreak) isDefault() bool {
+	return tie == "" || tie == TieLargerPacks
+}
+
+// rule describes how the policy breaks ties
+func (tie TieBreak) rule() string {
+	switch tie {
+	case TieSmallerPacks:
+		return "the smallest largest pack"
+	case TieFewestSizes:
+		return "the fewest distinct sizes, then the largest packs"
+	case TieLexicographic:
+		return "the lexicographically smallest list of packages"
+	default:
+		return "the largest packs"
+	}
+}
+
+// optimalStep reports whether a package of sizes[i] can be the last one of
+// the best packing of total, leaving the best packing of what is left
+func (t *packingTable) optimalStep(total, i int) bool {
+	size := t.sizes[i]
+	if size > total || t.counts[total] < 0 {
+		return false
+	}
+
+	prev := total - size
+	if t.counts[prev] != t.counts[total]-1 {
+		return false
+	}
+
+	return t.costs == nil || t.costs[prev] == t.costs[total]-int64(t.prices[i])
+}
+
+// packagesBy rebuilds the best packages that add up to total, choosing among
+// equally good packings by the tie-break policy. forced is a size that is
+// already part of the packing, or 0.
+func (t *packingTable) packagesBy(total int, tie TieBreak, forced int) map[int]int {
+	switch tie {
+	case TieSmallerPacks:
+		return t.smallerPacks(total)
+	case TieFewestSizes:
+		return t.fewestSizes(total, forced)
+	case TieLexicographic:
+		return t.smallestFirst(total)
+	default:
+		return t.packages(total)
+	}
+}
+
+// smallestFirst rebuilds the best packages of total taking the smallest size
+// at every step. Any part of a best packing is a best packing of its own
+// total, so the steps can be taken in any order and the packages come out in
+// lexicographically smallest order.
+func (t *packingTable) smallestFirst(total int) map[int]int {
+	counts := make(map[int]int)
+	for total > 0 {
+		for i, size := range t.sizes {
+			if t.optimalStep(total, i) {
+				counts[size]++
+				total -= size
+				break
+			}
+		}
+	}
+
+	return counts
+}
+
+// smallerPacks rebuilds the best packing of total whose packages, largest
+// first, are lexicographically smallest. smallest[u] is the index of the
+// smallest possible largest pack of a best packing of u.
+func (t *packingTable) smallerPacks(total int) map[int]int {
+	smallest := make([]int32, total+1)
+	for u := 1; u <= total; u++ {
+		smallest[u] = -1
+		for i := range t.sizes {
+			if !t.optimalStep(u, i) {
+				continue
+			}
+
+			largest := max(int32(i), smallest[u-t.sizes[i]])
+			if smallest[u] < 0 || largest < smallest[u] {
+				smallest[u] = largest
+			}
+		}
+	}
+
+	counts := make(map[int]int)
+	for total > 0 {
+		size := t.sizes[smallest[total]]
+		counts[size]++
+		total -= size
+	}
+
+	return counts
+}
+
+// fewestSizes rebuilds the best packing of total that uses the fewest
+// distinct sizes, counting forced, preferring larger packs among those. It
+// tries every set of the sizes some best packing uses, smallest sets first.
+func (t *packingTable) fewestSizes(total int, forced int) map[int]int {
+	usable := t.usableSizes(total)
+
+	var always []int
+	var optional []int
+	for i, size := range t.sizes {
+		switch {
+		case size == forced:
+			always = append(always, i)
+		case usable[i]:
+			optional = append(optional, i)
+		}
+	}
+
+	var best map[int]int
+	cells := 0
+	for k := 0; k <= len(optional) && best == nil; k++ {
+		combination := make([]int, k)
+		for j := range combination {
+			combination[j] = j
+		}
+
+		for {
+			allow := make([]bool, len(t.sizes))
+			for _, i := range always {
+				allow[i] = true
+			}
+			for _, j := range combination {
+				allow[optional[j]] = true
+			}
+
+			cells += total * (len(always) + k)
+			if cells > maxTieBreakCells {
+				break
+			}
+
+			if counts, ok := t.restricted(total, allow); ok && (best == nil || largerPacks(counts, best)) {
+				best = counts
+			}
+
+			if !nextCombination(combination, len(optional)) {
+				break
+			}
+		}
+
+		if cells > maxTieBreakCells {
+			break
+		}
+	}
+
+	if best == nil {
+		return t.packages(total)
+	}
+
+	return best
+}
+
+// usableSizes reports which sizes are part of some best packing of total
+func (t *packingTable) usableSizes(total int) []bool {
+	usable := make([]bool, len(t.sizes))
+	seen := make([]bool, total+1)
+	seen[total] = true
+
+	for u := total; u > 0; u-- {
+		if !seen[u] {
+			continue
+		}
+
+		for i, size := range t.sizes {
+			if t.optimalStep(u, i) {
+				usable[i] = true
+				seen[u-size] = true
+			}
+		}
+	}
+
+	return usable
+}
+
+// restricted rebuilds the best packing of total from only the allowed
+// sizes, taking the largest at every step, or returns false if every best
+// packing needs another size
+func (t *packingTable) restricted(total int, allow []bool) (map[int]int, bool) {
+	// done[u] reports whether a best packing of u uses only allowed sizes
+	done := make([]bool, total+1)
+	done[0] = true
+	for u := 1; u <= total; u++ {
+		for i, size := range t.sizes {
+			if allow[i] && t.optimalStep(u, i) && done[u-size] {
+				done[u] = true
+				break
+			}
+		}
+	}
+
+	if !done[total] {
+		return nil, false
+	}
+
+	counts := make(map[int]int)
+	for total > 0 {
+		for i := len(t.sizes) - 1; i >= 0; i-- {
+			size := t.sizes[i]
+			if allow[i] && t.optimalStep(total, i) && done[total-size] {
+				counts[size]++
+				total -= size
+				break
+			}
+		}
+	}
+
+	return counts, true
+}
+
+// largerPacks reports whether packing a has more of the largest size the two
+// packings differ in
+func largerPacks(a, b map[int]int) bool {
+	largest, more := 0, false
+	for size, count := range a {
+		if count != b[size] && size > largest {
+			largest, more = size, count > b[size]
+		}
+	}
+	for size, count := range b {
+		if count != a[size] && size > largest {
+			largest, more = size, a[size] > count
+		}
+	}
+
+	return more
+}
+
+// nextCombination advances the ascending indices to the next combination of
+// len(indices) out of n, or returns false after the last one
+func nextCombination(indices []int, n int) bool {
+	k := len(indices)
+	for j := k - 1; j >= 0; j-- {
+		if indices[j] < n-k+j {
+			indices[j]++
+			for l := j + 1; l < k; l++ {
+				indices[l] = indices[l-1] + 1
+			}
+			return true
+		}
+	}
+
+	return false
+}
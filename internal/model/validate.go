@@ -0,0 +1,214 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxAmount is the largest order amount the solver accepts
+	MaxAmount = 1_000_000_000
+	// MaxSize is the largest package size the solver accepts
+	MaxSize = 1_000_000
+	// MaxSizes is the most package sizes the solver accepts, as each total of
+	// a packing table tries every size
+	MaxSizes = 1000
+	// MaxPrice is the highest package price the solver accepts
+	MaxPrice = 1_000_000_000
+	// MaxWeight is the heaviest package weight the solver accepts
+	MaxWeight = 1_000_000_000
+)
+
+var (
+	// ErrEmptySizes is returned when no package sizes are given
+	ErrEmptySizes = errors.New("no package sizes given")
+	// ErrInvalidSize is returned when a package size is not positive or too large
+	ErrInvalidSize = errors.New("invalid package size")
+	// ErrTooManySizes is returned when more than MaxSizes package sizes are given
+	ErrTooManySizes = errors.New("too many package sizes")
+	// ErrDuplicateSize is returned when a package size is listed more than once
+	ErrDuplicateSize = errors.New("duplicate package size")
+	// ErrNonPositiveAmount is returned when the order amount is zero or negative
+	ErrNonPositiveAmount = errors.New("order amount must be positive")
+	// ErrAmountTooLarge is returned when the order amount exceeds MaxAmount
+	ErrAmountTooLarge = errors.New("order amount is too large")
+	// ErrCatalogueTooWide is returned when the package sizes need a larger
+	// table than the solver allows to pack an amount. Large coprime sizes
+	// such as 4999 and 5000 only need one below the amounts their residues
+	// cover, and then only when the table would be too large as well.
+	ErrCatalogueTooWide = errors.New("catalogue is too wide to pack the amount")
+	// ErrInvalidPrice is returned when a price is negative or the prices do not
+	// line up with the sizes
+	ErrInvalidPrice = errors.New("invalid package price")
+	// ErrInvalidWeight is returned when a weight is negative or the weights do
+	// not line up with the sizes
+	ErrInvalidWeight = errors.New("invalid package weight")
+	// ErrMissingPrices is returned when costs are minimised without prices
+	ErrMissingPrices = errors.New("package prices are required")
+	// ErrUnknownObjective is returned for an objective the solver does not know
+	ErrUnknownObjective = errors.New("unknown objective")
+	// ErrInvalidStock is returned when a stock level is negative
+	ErrInvalidStock = errors.New("invalid stock level")
+	// ErrTooManyPackages is returned by Pack when an order has more than
+	// MaxResultPackages packages to list
+	ErrTooManyPackages = errors.New("too many packages to list")
+)
+
+// Validate checks that the package sizes can be used for packing
+func (p Packages) Validate() error {
+	if len(p.Sizes) == 0 {
+		return ErrEmptySizes
+	}
+
+	if len(p.Sizes) > MaxSizes {
+		return fmt.Errorf("%w: %d sizes, at most %d are allowed", ErrTooManySizes, len(p.Sizes), MaxSizes)
+	}
+
+	seen := make(map[int]bool, len(p.Sizes))
+	for _, size := range p.Sizes {
+		if size <= 0 || size > MaxSize {
+			return fmt.Errorf("%w: %d", ErrInvalidSize, size)
+		}
+
+		if seen[size] {
+			return fmt.Errorf("%w: %d", ErrDuplicateSize, size)
+		}
+		seen[size] = true
+	}
+
+	if len(p.Prices) != 0 && len(p.Prices) != len(p.Sizes) {
+		return fmt.Errorf("%w: got %d prices for %d sizes", ErrInvalidPrice, len(p.Prices), len(p.Sizes))
+	}
+
+	for _, price := range p.Prices {
+		if price < 0 || price > MaxPrice {
+			return fmt.Errorf("%w: %d", ErrInvalidPrice, price)
+		}
+	}
+
+	if len(p.Weights) != 0 && len(p.Weights) != len(p.Sizes) {
+		return fmt.Errorf("%w: got %d weights for %d sizes", ErrInvalidWeight, len(p.Weights), len(p.Sizes))
+	}
+
+	for _, weight := range p.Weights {
+		if weight < 0 || weight > MaxWeight {
+			return fmt.Errorf("%w: %d", ErrInvalidWeight, weight)
+		}
+	}
+
+	if p.Scale != nil {
+		if err := p.Scale.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if p.Hierarchy != nil {
+		return p.Hierarchy.validate(p.Sizes)
+	}
+
+	return nil
+}
+
+// validateAmount checks that an order amount can be packed
+func validateAmount(amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("%w: %d", ErrNonPositiveAmount, amount)
+	}
+
+	if amount > MaxAmount {
+		return fmt.Errorf("%w: %d exceeds %d", ErrAmountTooLarge, amount, MaxAmount)
+	}
+
+	return nil
+}
+
+// validateObjective checks that the objective is known and can be met
+func (p Packages) validateObjective(objective Objective) error {
+	switch objective {
+	case "", ObjectivePackages:
+		return nil
+	case ObjectiveCost:
+		if len(p.Prices) == 0 {
+			return ErrMissingPrices
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownObjective, objective)
+	}
+}
+
+// Pack validates the package sizes and the amount, then returns the packages
+// that ship the fewest items and, among those, the fewest packages. It never
+// modifies p, so the same Packages can be packed from many goroutines.
+func (p Packages) Pack(amount int) ([]int, error) {
+	order, err := p.Solve(amount, SolveOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Result == nil {
+		return nil, fmt.Errorf("%w: %d packages", ErrTooManyPackages, order.Packing.Packages)
+	}
+
+	return order.Result, nil
+}
+
+// ValidateOrder checks that amount can be packed with p under the options
+// without solving it
+func (p Packages) ValidateOrder(amount int, opts SolveOptions) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if err := p.validateObjective(opts.Objective); err != nil {
+		return err
+	}
+
+	if err := validateAmount(amount); err != nil {
+		return err
+	}
+
+	if err := validateStock(opts.Stock); err != nil {
+		return err
+	}
+
+	if err := validateTieBreak(opts.TieBreak); err != nil {
+		return err
+	}
+
+	strategy, err := LookupStrategy(opts.StrategyName())
+	if err != nil {
+		return err
+	}
+
+	if checker, ok := strategy.(OptionChecker); ok {
+		return checker.Supports(p, opts)
+	}
+
+	return nil
+}
+
+// Solve validates its inputs and packs amount under the chosen options,
+// returning the order with its packages and their total cost
+func (p Packages) Solve(amount int, opts SolveOptions) (Order, error) {
+	return p.SolveContext(context.Background(), amount, opts)
+}
+
+// newOrder builds the order for the packing chosen for amount
+func (p Packages) newOrder(amount int, packing Packing) Order {
+	order := Order{Amount: amount, Packing: packing, Cost: p.costOf(packing.Counts())}
+	if order.Packing.Packages <= MaxResultPackages {
+		order.Result = order.Packing.Flatten()
+	}
+
+	if p.Hierarchy != nil {
+		order.Tree = p.Hierarchy.Nest(packing)
+	}
+
+	if p.Scale != nil {
+		order.Quantities = p.Scale.quantities(amount, packing)
+	}
+
+	return order
+}
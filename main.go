@@ -1,17 +1,155 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/mxnyawi/gymSharkTask/internal/db"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
 	"github.com/mxnyawi/gymSharkTask/pkg/api"
 )
 
 func main() {
-	dbManager, err := db.InitDB()
+	if len(os.Args) > 1 && os.Args[1] == "recommend" {
+		if err := recommend(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to recommend package sizes: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(); err != nil {
+			log.Fatalf("Failed to migrate order history: %v", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbManager, err := db.InitBackend()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	api.StartServer(dbManager)
+	err = api.StartServer(ctx, dbManager)
+	closeBackend(dbManager, &err)
+	if err != nil {
+		log.Fatalf("Failed to run server: %v", err)
+	}
+}
+
+// closeBackend closes the database. A failure to close is reported in *err,
+// unless it already holds an earlier error.
+func closeBackend(backend db.Backend, err *error) {
+	if closeErr := backend.Close(); closeErr != nil && *err == nil {
+		*err = fmt.Errorf("failed to close database: %w", closeErr)
+	}
+}
+
+// recommend prints package sizes that would have packed the stored order
+// history better than the current sizes
+func recommend(args []string) (err error) {
+	flags := flag.NewFlagSet("recommend", flag.ContinueOnError)
+	count := flags.Int("n", 0, "number of package sizes to propose, by default as many as the current sizes")
+	include := flags.String("include", "", "comma-separated sizes the proposal must keep")
+	candidates := flags.String("candidates", "", "comma-separated sizes to choose from")
+	current := flags.String("current", "", "comma-separated current sizes, by default those of the latest order")
+	packageCost := flags.Int("package-cost", 0, "items of overshoot one package is worth")
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := model.RecommendOptions{Count: *count, PackageCost: *packageCost}
+	var currentSizes []int
+	for _, list := range []struct {
+		value string
+		sizes *[]int
+	}{{*include, &opts.Include}, {*candidates, &opts.Candidates}, {*current, &currentSizes}} {
+		sizes, err := parseSizes(list.value)
+		if err != nil {
+			return err
+		}
+		*list.sizes = sizes
+	}
+
+	dbManager, err := db.OpenBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend(dbManager, &err)
+
+	bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
+	if err != nil {
+		return err
+	}
+
+	demand, err := db.ReadDemand(dbManager, bucketName, scopeName, collectionName)
+	if err != nil {
+		return err
+	}
+
+	if len(currentSizes) == 0 {
+		currentSizes = demand.LatestSizes
+	}
+	if opts.Count == 0 {
+		opts.Count = len(currentSizes)
+	}
+
+	recommendation, err := model.Recommend(demand.Amounts, currentSizes, opts)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(recommendation)
+}
+
+// migrate splits the order history document into one document per order
+func migrate() (err error) {
+	dbManager, err := db.OpenBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend(dbManager, &err)
+
+	bucketName, scopeName, collectionName, documentID, err := dbManager.GetDBCreds()
+	if err != nil {
+		return err
+	}
+
+	migrated, err := db.MigrateHistory(dbManager, bucketName, scopeName, collectionName, documentID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %d orders from %s", migrated, documentID)
+	return nil
+}
+
+// parseSizes parses a comma-separated list of package sizes
+func parseSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		size, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package size %q: %w", field, err)
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
 }
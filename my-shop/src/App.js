@@ -13,6 +13,25 @@ function App() {
   const [loginError, setLoginError] = useState('');
 
 
+  // Formats a grouped packing such as "2 x 5000, 1 x 250"
+  const formatPacking = (packing) =>
+    packing.packs.map(pack => `${pack.count} x ${pack.size}`).join(', ');
+
+  const formatHistoryItem = (item) => {
+    if (item.multiLine) {
+      return item.multiLine.lines
+        .map(line => `${line.sku}: ${line.order.amount} -> ${formatPacking(line.order.packing)}`)
+        .join('; ');
+    }
+
+    if (item.order.packing) {
+      return `Amount: ${item.order.amount}, Packages: ${formatPacking(item.order.packing)}`;
+    }
+
+    // Orders stored before packings were grouped only have the flat result
+    return `Amount: ${item.order.amount}, Result: ${(item.order.result || []).join(', ')}`;
+  };
+
   const handlePackageSizeChange = (index, value) => {
     setPackageSizes(packageSizes.map((size, i) => (i === index ? value : size)));
   };
@@ -62,12 +81,12 @@ function App() {
   
       if (response.status !== 204) {
         const data = await response.json();
-        setResponseMessage(`Amount: ${data.amount}, Result: ${data.result.join(', ')}`); // Store the response in the state
+        setResponseMessage(`Amount: ${data.amount}, Packages: ${formatPacking(data.packing)}, Overshoot: ${data.packing.overshoot}`); // Store the response in the state
       } else {
         setResponseMessage('POST request was successful but no content returned');
       }
 
-      const historyResponse = await fetch('http://'+process.env.REACT_APP_IP+':8080/getDocument', {
+      const historyResponse = await fetch('http://'+process.env.REACT_APP_IP+':8080/orders?limit=500', {
         method: 'GET',
         headers: {
           'Content-Type': 'application/json',
@@ -80,7 +99,7 @@ function App() {
       }
 
       const historyData = await historyResponse.json();
-      setOrderHistory(historyData.history); // Store the history in the state
+      setOrderHistory(historyData.orders); // Store the history in the state
     } catch (error) {
       console.error('Failed to fetch:', error);
     }
@@ -143,7 +162,7 @@ function App() {
           <div className="order-history">
             {orderHistory.map((order, i) => (
               <div key={i} className="order-history-item">
-                <p>Order {i + 1}: Amount: {order.order.amount}, Result: {order.order.result.join(', ')}</p>
+                <p>Order {i + 1}: {formatHistoryItem(order)}</p>
               </div>
             ))}
           </div>
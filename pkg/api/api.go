@@ -1,16 +1,32 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mxnyawi/gymSharkTask/internal/db"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
 )
 
-// StartServer starts the server
-func StartServer(dbManager db.DBManagerInterface) {
+// shutdownTimeout is how long requests in flight may take to finish once the
+// server is stopped
+const shutdownTimeout = 30 * time.Second
+
+// StartServer starts the server, unless its settings are invalid, and runs it
+// until ctx is done. It then waits for the requests in flight, so the
+// database can be closed once it returns.
+func StartServer(ctx context.Context, dbManager db.DBManagerInterface) error {
+	err := ValidateConfig()
+	if err != nil {
+		return err
+	}
 
 	r := mux.NewRouter()
 
@@ -18,7 +34,35 @@ func StartServer(dbManager db.DBManagerInterface) {
 	r.Use(AuthMiddleware)
 
 	Routes(dbManager)
-	http.ListenAndServe(":8080", nil)
+
+	server := &http.Server{Addr: ":8080"}
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+
+		stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(stopCtx)
+	}()
+
+	err = server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return <-shutdown
+}
+
+// ValidateConfig checks the server settings every order depends on, so a bad
+// setting stops the server at startup instead of failing each order
+func ValidateConfig() error {
+	if name := os.Getenv("DEFAULT_STRATEGY"); name != "" {
+		if _, err := model.LookupStrategy(name); err != nil {
+			return fmt.Errorf("invalid DEFAULT_STRATEGY: %w", err)
+		}
+	}
+
+	return nil
 }
 
 // AuthMiddleware is a middleware function that checks for a valid authentication token
@@ -39,3 +83,21 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AdminMiddleware only lets requests carrying the ADMIN_TOKEN in the
+// X-Admin-Token header through to the admin routes. Without an ADMIN_TOKEN
+// the admin routes are closed.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminToken := os.Getenv("ADMIN_TOKEN")
+
+		token := r.Header.Get("X-Admin-Token")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			log.Println("Invalid admin token")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/mxnyawi/gymSharkTask/internal/model"
+)
+
+// defaultCatalogueCacheBytes is the memory the solver tables of the catalogue
+// cache may use when CATALOGUE_CACHE_MB is not set
+const defaultCatalogueCacheBytes = 256 << 20
+
+// CacheStats is a struct that contains the usage counts of the catalogue cache
+type CacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Size     int    `json:"size"`
+	Bytes    int    `json:"bytes"`
+	Capacity int    `json:"capacity"`
+}
+
+// CatalogueCache keeps the most recently used compiled catalogues, keyed by
+// their package set with the sizes in ascending order, for as long as their
+// solver tables fit in its capacity in bytes
+type CatalogueCache struct {
+	mu       sync.Mutex
+	capacity int
+	bytes    int
+	entries  map[string]*list.Element
+	recent   *list.List // of *cacheEntry, most recently used first
+	hits     uint64
+	misses   uint64
+}
+
+// cacheEntry is a compiled catalogue, its key and the bytes it was last
+// counted as using
+type cacheEntry struct {
+	key       string
+	catalogue *model.Catalogue
+	bytes     int
+}
+
+// NewCatalogueCache creates a cache whose solver tables use at most capacity
+// bytes
+func NewCatalogueCache(capacity int) *CatalogueCache {
+	return &CatalogueCache{
+		capacity: max(1, capacity),
+		entries:  make(map[string]*list.Element),
+		recent:   list.New(),
+	}
+}
+
+// newCatalogueCacheFromEnv creates a cache of CATALOGUE_CACHE_MB megabytes, or
+// of the default size if it is not set
+func newCatalogueCacheFromEnv() *CatalogueCache {
+	if size := envInt("CATALOGUE_CACHE_MB"); size > 0 {
+		return NewCatalogueCache(size << 20)
+	}
+	return NewCatalogueCache(defaultCatalogueCacheBytes)
+}
+
+// Solve packs the order with the cached catalogue of its package set,
+// stopping once ctx is done. Package sets that cannot be compiled are solved
+// without the cache.
+func (c *CatalogueCache) Solve(ctx context.Context, packages model.Packages, amount int, opts model.SolveOptions) (model.Order, error) {
+	key, catalogue, err := c.get(packages)
+	if err != nil {
+		return packages.SolveContext(ctx, amount, opts)
+	}
+
+	// The query may have built a table, so count the catalogue again
+	order, err := catalogue.SolveContext(ctx, amount, opts)
+	c.resize(key, catalogue)
+	return order, err
+}
+
+// get returns the key and the cached catalogue of the packages, adding the
+// catalogue on a miss. Compiling only validates the packages, so it is done
+// under the lock; tables are built by the queries that need them.
+func (c *CatalogueCache) get(packages model.Packages) (string, *model.Catalogue, error) {
+	key, err := catalogueKey(packages)
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.hits++
+		c.recent.MoveToFront(element)
+		return key, element.Value.(*cacheEntry).catalogue, nil
+	}
+	c.misses++
+
+	catalogue, err := model.CompileCatalogue(normalise(packages))
+	if err != nil {
+		return "", nil, err
+	}
+
+	entry := &cacheEntry{key: key, catalogue: catalogue, bytes: len(key)}
+	c.entries[key] = c.recent.PushFront(entry)
+	c.bytes += entry.bytes
+	c.evict()
+
+	return key, catalogue, nil
+}
+
+// resize counts the memory the catalogue uses now and evicts the least
+// recently used catalogues until the cache fits its capacity again
+func (c *CatalogueCache) resize(key string, catalogue *model.Catalogue) {
+	bytes := len(key) + catalogue.Bytes()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok || element.Value.(*cacheEntry).catalogue != catalogue {
+		return
+	}
+
+	entry := element.Value.(*cacheEntry)
+	c.bytes += bytes - entry.bytes
+	entry.bytes = bytes
+	c.evict()
+}
+
+// evict removes the least recently used catalogues until the cache fits its
+// capacity. The caller must hold mu.
+func (c *CatalogueCache) evict() {
+	for c.bytes > c.capacity && c.recent.Len() > 0 {
+		oldest := c.recent.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.recent.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.bytes -= entry.bytes
+	}
+}
+
+// Stats returns the hit and miss counts and the size of the cache
+func (c *CatalogueCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{Hits: c.hits, Misses: c.misses, Size: c.recent.Len(), Bytes: c.bytes, Capacity: c.capacity}
+}
+
+// catalogueKey returns the cache key of the packages, the same for any order
+// of the sizes
+func catalogueKey(packages model.Packages) (string, error) {
+	key, err := json.Marshal(normalise(packages))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// normalise returns a copy of the packages with the sizes in ascending order
+// and the prices in the same order. Weights are left out, as they never
+// change a packing.
+func normalise(packages model.Packages) model.Packages {
+	order := make([]int, len(packages.Sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return packages.Sizes[order[a]] < packages.Sizes[order[b]] })
+
+	normalised := model.Packages{Sizes: make([]int, len(order)), Hierarchy: packages.Hierarchy, Scale: packages.Scale}
+	if len(packages.Prices) == len(order) {
+		normalised.Prices = make([]int, len(order))
+	}
+
+	for i, index := range order {
+		normalised.Sizes[i] = packages.Sizes[index]
+		if normalised.Prices != nil {
+			normalised.Prices[i] = packages.Prices[index]
+		}
+	}
+
+	return normalised
+}
@@ -4,18 +4,120 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/mxnyawi/gymSharkTask/internal/db"
 	"github.com/mxnyawi/gymSharkTask/internal/model"
 )
 
-// OrderRequest is a struct that contains the order amount and package sizes
+// OrderRequest is a struct that contains the order amount, package sizes and
+// optional package prices, weights and hierarchy, packing objective, strategy,
+// tie-break policy and parcel limits. Products sold by weight or volume give
+// the order and package sizes as quantities instead, with an optional scale.
 type OrderRequest struct {
-	OrderAmount  int   `json:"orderAmount"`
-	PackageSizes []int `json:"packageSizes"`
+	OrderAmount       int                 `json:"orderAmount"`
+	PackageSizes      []int               `json:"packageSizes"`
+	OrderQuantity     *model.Quantity     `json:"orderQuantity,omitempty"`
+	PackageQuantities []model.Quantity    `json:"packageQuantities,omitempty"`
+	Scale             *model.Scale        `json:"scale,omitempty"`
+	PackagePrices     []int               `json:"packagePrices,omitempty"`
+	PackageWeights    []int               `json:"packageWeights,omitempty"`
+	Hierarchy         *model.Hierarchy    `json:"hierarchy,omitempty"`
+	Objective         model.Objective     `json:"objective,omitempty"`
+	Strategy          string              `json:"strategy,omitempty"`
+	TieBreak          model.TieBreak      `json:"tieBreak,omitempty"`
+	UseStock          bool                `json:"useStock,omitempty"`
+	Parcel            *model.ParcelLimits `json:"parcel,omitempty"`
+	Lines             []LineRequest       `json:"lines,omitempty"`
+}
+
+// LineRequest is a struct that contains one line of a multi-line order. The
+// package sizes are given inline or taken from a stored catalogue.
+type LineRequest struct {
+	SKU           string           `json:"sku"`
+	OrderAmount   int              `json:"orderAmount"`
+	PackageSizes  []int            `json:"packageSizes,omitempty"`
+	PackagePrices []int            `json:"packagePrices,omitempty"`
+	Hierarchy     *model.Hierarchy `json:"hierarchy,omitempty"`
+	Catalogue     string           `json:"catalogue,omitempty"`
+}
+
+// BatchRequest is a struct that contains the order amounts to pack with one
+// set of package sizes, and whether to store them in the order history. A
+// batch stored with a BatchID is only stored once, however often it is sent.
+type BatchRequest struct {
+	OrderAmounts  []int           `json:"orderAmounts"`
+	PackageSizes  []int           `json:"packageSizes"`
+	PackagePrices []int           `json:"packagePrices,omitempty"`
+	Objective     model.Objective `json:"objective,omitempty"`
+	Strategy      string          `json:"strategy,omitempty"`
+	TieBreak      model.TieBreak  `json:"tieBreak,omitempty"`
+	Persist       bool            `json:"persist,omitempty"`
+	BatchID       string          `json:"batchId,omitempty"`
+}
+
+// BatchResponse is a struct that contains the orders in the order of the
+// amounts, and the IDs they were stored as
+type BatchResponse struct {
+	Orders   []model.Order `json:"orders"`
+	OrderIDs []string      `json:"orderIds,omitempty"`
+}
+
+// maxBatchIDLength is the longest BatchID a batch can be stored with
+const maxBatchIDLength = 200
+
+// AlternativesRequest is a struct that contains the order amount, package
+// sizes and how many alternative packings to return
+type AlternativesRequest struct {
+	OrderAmount   int   `json:"orderAmount"`
+	PackageSizes  []int `json:"packageSizes"`
+	PackagePrices []int `json:"packagePrices,omitempty"`
+	Count         int   `json:"count,omitempty"`
+}
+
+// AlternativesResponse is a struct that contains the alternative packings, best first
+type AlternativesResponse struct {
+	Alternatives []model.Order `json:"alternatives"`
+}
+
+// RecommendRequest is a struct that contains the options for recommending
+// package sizes and the current sizes to compare against. Without current
+// sizes, those of the latest order are used.
+type RecommendRequest struct {
+	model.RecommendOptions
+	CurrentSizes []int `json:"currentSizes,omitempty"`
+}
+
+const (
+	// defaultOrderPageSize is how many orders are listed when no limit is given
+	defaultOrderPageSize = 50
+	// maxOrderPageSize is the most orders listed at once
+	maxOrderPageSize = 500
+)
+
+// defaultAnalyzeAmount is the smallest range of amounts analysed by default
+const defaultAnalyzeAmount = 10_000
+
+// defaultAlternatives is the number of alternatives returned when none is asked for
+const defaultAlternatives = 5
+
+// maxBodyBytes is the largest request body a handler reads, which fits a full
+// batch or a catalogue of model.MaxSizes sizes with their prices and weights
+const maxBodyBytes = 1 << 20
+
+// CatalogueRequest is a struct that contains a named set of package sizes
+type CatalogueRequest struct {
+	Name     string         `json:"name"`
+	Packages model.Packages `json:"packages"`
 }
 
 // UserRequest is a struct that contains the user credentials
@@ -29,7 +131,26 @@ type DocumentRequest struct {
 	Document db.Document `json:"document"`
 }
 
-var packages = &model.Packages{Sizes: []int{1, 5, 10}} // Default package sizes
+// OrderListResponse is a struct that contains a page of stored orders and the
+// ID to list the next page after, if there may be one
+type OrderListResponse struct {
+	Orders []db.OrderDocument `json:"orders"`
+	Next   string             `json:"next,omitempty"`
+}
+
+// OrderResponse is a struct that contains the order, its parcels and, when it
+// was asked for, the explanation of its packing
+type OrderResponse struct {
+	model.Order
+	Shipments   []model.Shipment   `json:"shipments"`
+	Explanation *model.Explanation `json:"explanation,omitempty"`
+}
+
+// ErrorResponse is a struct that contains a machine-readable error code and message
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
 
 // RegisterHandler registers a new user
 func RegisterHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
@@ -44,10 +165,10 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBMana
 	}
 
 	var user UserRequest
-	err := json.NewDecoder(r.Body).Decode(&user)
+	err := json.NewDecoder(limitBody(w, r)).Decode(&user)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -98,10 +219,10 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManager
 	}
 
 	var user UserRequest
-	err := json.NewDecoder(r.Body).Decode(&user)
+	err := json.NewDecoder(limitBody(w, r)).Decode(&user)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -144,8 +265,9 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManager
 	json.NewEncoder(w).Encode(map[string]string{"message": "User authenticated"})
 }
 
-// PostOrderHandler creates a new order and finds the packages for it
-func PostOrderHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
+// PostOrderHandler creates a new order and finds the packages for it, with
+// the cached catalogue of its package set
+func PostOrderHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface, catalogues *CatalogueCache) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -157,43 +279,210 @@ func PostOrderHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBMan
 	}
 
 	var req OrderRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		writeBodyError(w, err)
+		return
+	}
+
+	explain := r.URL.Query().Get("explain") == "true"
+
+	if err := req.scaleQuantities(); err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	if len(req.Lines) > 0 {
+		if explain {
+			writeError(w, http.StatusBadRequest, "explain_not_supported", "Explain is not supported for multi-line orders")
+			return
+		}
+
+		if req.Parcel != nil {
+			writeError(w, http.StatusBadRequest, "shipping_not_supported", "Parcel limits are not supported for multi-line orders")
+			return
+		}
+
+		postMultiLineOrder(w, r, req, dbManager)
+		return
+	}
+
+	// Each request packs its own sizes, so concurrent orders never share state
+	packages := model.Packages{
+		Sizes:     req.PackageSizes,
+		Prices:    req.PackagePrices,
+		Weights:   req.PackageWeights,
+		Hierarchy: req.Hierarchy,
+		Scale:     req.Scale,
+	}
+	opts := req.solveOptions()
+	limits := req.parcelLimits()
+
+	err = packages.ValidateOrder(req.OrderAmount, opts)
+	if err == nil {
+		err = packages.ValidateShipping(limits)
+	}
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
+		return
+	}
+
+	if req.UseStock {
+		stock, err := dbManager.GetStock(bucketName, scopeName, collectionName, db.GetStockID())
+		if errors.Is(err, db.ErrNotFound) {
+			log.Println(err)
+			writeError(w, http.StatusConflict, "stock_not_configured", "No stock levels are configured")
+			return
+		}
+
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Could not get stock levels", http.StatusInternalServerError)
+			return
+		}
+
+		opts.Stock = stock.Levels
+	}
+
+	var response OrderResponse
+	if explain {
+		var explanation model.Explanation
+		response.Order, explanation, err = packages.ExplainContext(r.Context(), req.OrderAmount, opts)
+		response.Explanation = &explanation
+	} else {
+		response.Order, err = catalogues.Solve(r.Context(), packages, req.OrderAmount, opts)
+	}
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writePackingError(w, err)
 		return
 	}
+	order := response.Order
+
+	response.Shipments, err = packages.Ship(order.Packing, limits)
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	if req.UseStock {
+		err = dbManager.DecrementStock(bucketName, scopeName, collectionName, db.GetStockID(), order.Packing.Counts())
+		if errors.Is(err, db.ErrInsufficientStock) {
+			log.Println(err)
+			writeError(w, http.StatusConflict, "insufficient_stock", err.Error())
+			return
+		}
 
-	order := &model.Order{Amount: req.OrderAmount}
-	packages.Sizes = req.PackageSizes
+		if errors.Is(err, db.ErrNotFound) {
+			log.Println(err)
+			writeError(w, http.StatusConflict, "stock_not_configured", "No stock levels are configured")
+			return
+		}
 
-	packages.FindPackages(order, packages)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Could not update stock levels", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// Create a document with the order and packages
 	document := db.Document{
-		Order:    *order,
-		Packages: *packages,
+		Order:     order,
+		Packages:  packages,
+		Strategy:  opts.StrategyName(),
+		Shipments: response.Shipments,
 	}
 
-	bucketName, scopeName, collectionName, documentID, err := dbManager.GetDBCreds()
+	err = storeOrders(dbManager, bucketName, scopeName, collectionName, document)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
+
+		// The order was never recorded, so the packages it took go back
+		if req.UseStock {
+			err = db.RestoreStock(dbManager, bucketName, scopeName, collectionName, db.GetStockID(), order.Packing.Counts())
+			if err != nil {
+				log.Println(err)
+			}
+		}
+
+		http.Error(w, "Could not write order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// postMultiLineOrder packs every line of an order, stopping once the request
+// is done, and stores them as one document
+func postMultiLineOrder(w http.ResponseWriter, r *http.Request, req OrderRequest, dbManager db.DBManagerInterface) {
+	if req.UseStock {
+		writeError(w, http.StatusBadRequest, "stock_not_supported", "Stock is not supported for multi-line orders")
 		return
 	}
 
-	// Fetch the existing document
-	existingDocument, err := dbManager.GetDocument(bucketName, scopeName, collectionName, documentID)
+	// A line packs either its own packages or a catalogue's, never a mix
+	for _, line := range req.Lines {
+		if line.Catalogue != "" && (len(line.PackageSizes) > 0 || len(line.PackagePrices) > 0 || line.Hierarchy != nil) {
+			writeError(w, http.StatusBadRequest, "conflicting_packages", "Line "+line.SKU+" sets both a catalogue and its own packages")
+			return
+		}
+	}
+
+	bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Could not find order history", http.StatusInternalServerError)
+		http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
 		return
 	}
 
-	// Add the new document to the history
-	existingDocument.History = append(existingDocument.History, document)
+	items := make([]model.LineItem, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		packages := model.Packages{Sizes: line.PackageSizes, Prices: line.PackagePrices, Hierarchy: line.Hierarchy}
+
+		if line.Catalogue != "" {
+			catalogue, err := dbManager.GetCatalogue(bucketName, scopeName, collectionName, db.CatalogueID(line.Catalogue))
+			if errors.Is(err, db.ErrNotFound) {
+				log.Println(err)
+				writeError(w, http.StatusBadRequest, "unknown_catalogue", "Unknown catalogue "+line.Catalogue)
+				return
+			}
+
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Could not get catalogue", http.StatusInternalServerError)
+				return
+			}
+
+			packages = *catalogue
+		}
+
+		items = append(items, model.LineItem{SKU: line.SKU, Amount: line.OrderAmount, Packages: packages})
+	}
 
-	err = dbManager.WriteDocument(bucketName, scopeName, collectionName, documentID, existingDocument)
+	opts := req.solveOptions()
+	order, err := model.SolveLinesContext(r.Context(), items, opts)
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	document := db.Document{MultiLine: &order, Strategy: opts.StrategyName()}
+	err = storeOrders(dbManager, bucketName, scopeName, collectionName, document)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Could not write order", http.StatusInternalServerError)
@@ -205,6 +494,460 @@ func PostOrderHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBMan
 	json.NewEncoder(w).Encode(order)
 }
 
+// CacheStatsHandler reports the hit and miss counts of the catalogue cache
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request, catalogues *CatalogueCache) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(catalogues.Stats())
+}
+
+// scaleQuantities converts an order given as quantities to the whole numbers
+// of its scale, which is the coarsest that keeps every quantity whole unless
+// the request sets one
+func (req *OrderRequest) scaleQuantities() error {
+	if req.OrderQuantity == nil && len(req.PackageQuantities) == 0 {
+		return nil
+	}
+
+	switch {
+	case req.OrderQuantity == nil || len(req.PackageQuantities) == 0:
+		return fmt.Errorf("%w: orderQuantity and packageQuantities must be given together", model.ErrInvalidQuantity)
+	case req.OrderAmount != 0 || len(req.PackageSizes) > 0:
+		return fmt.Errorf("%w: quantities cannot be mixed with orderAmount or packageSizes", model.ErrInvalidQuantity)
+	case len(req.Lines) > 0:
+		return fmt.Errorf("%w: quantities are not supported for multi-line orders", model.ErrInvalidQuantity)
+	}
+
+	if req.Scale == nil {
+		scale, err := model.NewScale(append([]model.Quantity{*req.OrderQuantity}, req.PackageQuantities...)...)
+		if err != nil {
+			return err
+		}
+		req.Scale = &scale
+	}
+
+	amount, err := req.Scale.Whole(*req.OrderQuantity)
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int, len(req.PackageQuantities))
+	for i, quantity := range req.PackageQuantities {
+		sizes[i], err = req.Scale.Whole(quantity)
+		if err != nil {
+			return err
+		}
+	}
+
+	req.OrderAmount, req.PackageSizes = amount, sizes
+	return nil
+}
+
+// solveOptions returns the options for packing the order. Without a strategy
+// in the request, the server default from DEFAULT_STRATEGY is used. The time
+// budget comes from SOLVE_BUDGET.
+func (req OrderRequest) solveOptions() model.SolveOptions {
+	strategy := req.Strategy
+	if strategy == "" {
+		strategy = os.Getenv("DEFAULT_STRATEGY")
+	}
+
+	return model.SolveOptions{
+		Objective: req.Objective,
+		Strategy:  strategy,
+		TieBreak:  req.TieBreak,
+		Budget:    envDuration("SOLVE_BUDGET"),
+	}
+}
+
+// BatchHandler packs many order amounts with the same package sizes. The
+// orders are only stored when asked, with a single write that stores all of
+// them or none. A batch whose BatchID was already stored is a conflict.
+func BatchHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req BatchRequest
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		writeBodyError(w, err)
+		return
+	}
+
+	if len(req.BatchID) > maxBatchIDLength {
+		writeError(w, http.StatusBadRequest, "invalid_batch_id", fmt.Sprintf("batchId is longer than %d characters", maxBatchIDLength))
+		return
+	}
+
+	packages := model.Packages{Sizes: req.PackageSizes, Prices: req.PackagePrices}
+	opts := OrderRequest{Objective: req.Objective, Strategy: req.Strategy, TieBreak: req.TieBreak}.solveOptions()
+
+	orders, err := packages.SolveBatchContext(r.Context(), req.OrderAmounts, opts)
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	var orderIDs []string
+	if req.Persist {
+		bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
+			return
+		}
+
+		documents := make([]db.Document, len(orders))
+		for i, order := range orders {
+			documents[i] = db.Document{Order: order, Packages: packages, Strategy: opts.StrategyName()}
+		}
+
+		orderIDs, err = storeBatch(dbManager, bucketName, scopeName, collectionName, req.BatchID, documents)
+		if req.BatchID != "" && errors.Is(err, db.ErrDocumentExists) {
+			log.Println(err)
+			writeError(w, http.StatusConflict, "batch_exists", fmt.Sprintf("Batch %s is already stored", req.BatchID))
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Could not write orders", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(BatchResponse{Orders: orders, OrderIDs: orderIDs})
+}
+
+// AlternativesHandler returns the best packings for an order without storing it
+func AlternativesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req AlternativesRequest
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		writeBodyError(w, err)
+		return
+	}
+
+	if req.Count == 0 {
+		req.Count = defaultAlternatives
+	}
+
+	packages := model.Packages{Sizes: req.PackageSizes, Prices: req.PackagePrices}
+	alternatives, err := packages.Alternatives(req.OrderAmount, req.Count)
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AlternativesResponse{Alternatives: alternatives})
+}
+
+// parcelLimits returns the parcel limits for the order. Without limits in the
+// request, the server defaults from PARCEL_MAX_PACKAGES and PARCEL_MAX_WEIGHT
+// are used.
+func (req OrderRequest) parcelLimits() model.ParcelLimits {
+	if req.Parcel != nil {
+		return *req.Parcel
+	}
+
+	return model.ParcelLimits{
+		MaxPackages: envInt("PARCEL_MAX_PACKAGES"),
+		MaxWeight:   envInt("PARCEL_MAX_WEIGHT"),
+	}
+}
+
+// envInt reads a whole number from the environment, or 0 if it is unset or invalid
+func envInt(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("invalid %s: %v", key, err)
+		return 0
+	}
+
+	return n
+}
+
+// envDuration reads a duration such as 500ms from the environment, or 0 if it
+// is unset or invalid
+func envDuration(key string) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("invalid %s: %v", key, err)
+		return 0
+	}
+
+	return d
+}
+
+// AnalyzeHandler reports how a set of package sizes packs a range of amounts.
+// The sizes are given as ?sizes=250,500,1000 with optional from and to amounts.
+func AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var sizes []int
+	for _, field := range strings.Split(query.Get("sizes"), ",") {
+		if field == "" {
+			continue
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "Invalid package size "+field)
+			return
+		}
+		sizes = append(sizes, size)
+	}
+
+	largest := 0
+	for _, size := range sizes {
+		largest = max(largest, size)
+	}
+
+	from, to := 1, min(max(defaultAnalyzeAmount, 10*largest), model.MaxAnalyzeAmount)
+	for key, value := range map[string]*int{"from": &from, "to": &to} {
+		if query.Get(key) == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(query.Get(key))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "Invalid "+key+" amount "+query.Get(key))
+			return
+		}
+		*value = n
+	}
+
+	analysis, err := model.Packages{Sizes: sizes}.Analyze(from, to)
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(analysis)
+}
+
+// RecommendHandler proposes package sizes that would have packed the stored
+// order history better than the current sizes
+func RecommendHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req RecommendRequest
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		writeBodyError(w, err)
+		return
+	}
+
+	bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
+		return
+	}
+
+	demand, err := db.ReadDemand(dbManager, bucketName, scopeName, collectionName)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not list orders", http.StatusInternalServerError)
+		return
+	}
+
+	current := req.CurrentSizes
+	if len(current) == 0 {
+		current = demand.LatestSizes
+	}
+
+	opts := req.RecommendOptions
+	if opts.Count == 0 {
+		opts.Count = len(current)
+	}
+
+	recommendation, err := model.Recommend(demand.Amounts, current, opts)
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recommendation)
+}
+
+// storeOrders stores each document as a new order in a single write
+func storeOrders(dbManager db.DBManagerInterface, bucketName, scopeName, collectionName string, documents ...db.Document) error {
+	_, err := storeBatch(dbManager, bucketName, scopeName, collectionName, "", documents)
+	return err
+}
+
+// storeBatch stores each document as a new order of the batch in a single
+// write and returns their IDs. An empty batch key stores the orders unkeyed.
+func storeBatch(dbManager db.DBManagerInterface, bucketName, scopeName, collectionName, batch string, documents []db.Document) ([]string, error) {
+	orders := make([]db.OrderDocument, len(documents))
+	ids := make([]string, len(documents))
+	for i, document := range documents {
+		orders[i] = db.NewOrderDocument(document)
+		orders[i].Batch = batch
+		ids[i] = orders[i].ID
+	}
+
+	err := dbManager.InsertOrders(bucketName, scopeName, collectionName, orders)
+	if err != nil {
+		return nil, fmt.Errorf("could not write orders: %w", err)
+	}
+
+	return ids, nil
+}
+
+// ListOrdersHandler lists the stored orders in the order they were placed,
+// a page at a time. The next page starts after the ID given as ?after=.
+func ListOrdersHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultOrderPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxOrderPageSize {
+			writeError(w, http.StatusBadRequest, "invalid_limit", fmt.Sprintf("limit must be between 1 and %d", maxOrderPageSize))
+			return
+		}
+		limit = n
+	}
+
+	bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
+		return
+	}
+
+	orders, err := dbManager.ListOrders(bucketName, scopeName, collectionName, r.URL.Query().Get("after"), limit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not list orders", http.StatusInternalServerError)
+		return
+	}
+
+	response := OrderListResponse{Orders: orders}
+	if len(orders) == limit {
+		response.Next = orders[len(orders)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// SetCatalogueHandler stores a named set of package sizes for multi-line orders
+func SetCatalogueHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req CatalogueRequest
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		writeBodyError(w, err)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Catalogue name is required", http.StatusBadRequest)
+		return
+	}
+
+	err = req.Packages.Validate()
+	if err != nil {
+		log.Println(err)
+		writePackingError(w, err)
+		return
+	}
+
+	bucketName, scopeName, collectionName, _, err := dbManager.GetDBCreds()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not get database credentials", http.StatusInternalServerError)
+		return
+	}
+
+	err = dbManager.WriteDocument(bucketName, scopeName, collectionName, db.CatalogueID(req.Name), req.Packages)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Could not store catalogue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Catalogue created"})
+}
+
 // CreateAdminUserHandler creates an admin user in the database
 func CreateAdminUserHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
 	if r.Method != http.MethodPost {
@@ -218,10 +961,10 @@ func CreateAdminUserHandler(w http.ResponseWriter, r *http.Request, dbManager db
 	}
 
 	var req UserRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -255,10 +998,10 @@ func SetDocumentHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBM
 	}
 
 	var req DocumentRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(limitBody(w, r)).Decode(&req)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -301,7 +1044,7 @@ func GetDocumentHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBM
 	}
 
 	// Call the GetDocument method
-	content, err := dbManager.GetDocument(bucketName, scopeName, collectionName, documentID)
+	content, _, err := dbManager.GetDocument(bucketName, scopeName, collectionName, documentID)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Could not get document", http.StatusInternalServerError)
@@ -320,3 +1063,81 @@ func GetDocumentHandler(w http.ResponseWriter, r *http.Request, dbManager db.DBM
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonContent)
 }
+
+// packingErrorCodes maps the model's errors to statuses and machine-readable codes
+var packingErrorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{model.ErrEmptySizes, http.StatusBadRequest, "empty_sizes"},
+	{model.ErrInvalidSize, http.StatusBadRequest, "invalid_size"},
+	{model.ErrTooManySizes, http.StatusBadRequest, "too_many_sizes"},
+	{model.ErrDuplicateSize, http.StatusBadRequest, "duplicate_size"},
+	{model.ErrNonPositiveAmount, http.StatusBadRequest, "non_positive_amount"},
+	{model.ErrAmountTooLarge, http.StatusBadRequest, "amount_too_large"},
+	{model.ErrCatalogueTooWide, http.StatusUnprocessableEntity, "catalogue_too_wide"},
+	{model.ErrInvalidPrice, http.StatusBadRequest, "invalid_price"},
+	{model.ErrInvalidWeight, http.StatusBadRequest, "invalid_weight"},
+	{model.ErrInvalidLimits, http.StatusBadRequest, "invalid_limits"},
+	{model.ErrMissingWeights, http.StatusBadRequest, "missing_weights"},
+	{model.ErrPackageTooHeavy, http.StatusBadRequest, "package_too_heavy"},
+	{model.ErrTooManyShipments, http.StatusBadRequest, "too_many_shipments"},
+	{model.ErrInvalidHierarchy, http.StatusBadRequest, "invalid_hierarchy"},
+	{model.ErrMissingPrices, http.StatusBadRequest, "missing_prices"},
+	{model.ErrUnknownObjective, http.StatusBadRequest, "unknown_objective"},
+	{model.ErrInvalidStock, http.StatusBadRequest, "invalid_stock"},
+	{model.ErrInsufficientStock, http.StatusConflict, "insufficient_stock"},
+	{model.ErrUnknownStrategy, http.StatusBadRequest, "unknown_strategy"},
+	{model.ErrUnsupportedOption, http.StatusBadRequest, "unsupported_option"},
+	{model.ErrUnknownTieBreak, http.StatusBadRequest, "unknown_tie_break"},
+	{model.ErrCancelled, http.StatusServiceUnavailable, "packing_cancelled"},
+	{model.ErrBudgetExceeded, http.StatusServiceUnavailable, "budget_exceeded"},
+	{model.ErrUnknownMeasure, http.StatusBadRequest, "unknown_measure"},
+	{model.ErrInvalidQuantity, http.StatusBadRequest, "invalid_quantity"},
+	{model.ErrInvalidAlternatives, http.StatusBadRequest, "invalid_alternatives"},
+	{model.ErrInvalidBatch, http.StatusBadRequest, "invalid_batch"},
+	{model.ErrInvalidRange, http.StatusBadRequest, "invalid_range"},
+	{model.ErrInvalidRecommendation, http.StatusBadRequest, "invalid_recommendation"},
+	{model.ErrNoHistory, http.StatusConflict, "no_history"},
+	{model.ErrNoLines, http.StatusBadRequest, "no_lines"},
+	{model.ErrMissingSKU, http.StatusBadRequest, "missing_sku"},
+	{model.ErrDuplicateSKU, http.StatusBadRequest, "duplicate_sku"},
+}
+
+// writePackingError writes a packing error as a JSON response with its error code
+func writePackingError(w http.ResponseWriter, err error) {
+	for _, known := range packingErrorCodes {
+		if errors.Is(err, known.err) {
+			writeError(w, known.status, known.code, err.Error())
+			return
+		}
+	}
+
+	writeError(w, http.StatusInternalServerError, "packing_failed", "Could not find packages for order")
+}
+
+// limitBody caps the request body at maxBodyBytes
+func limitBody(w http.ResponseWriter, r *http.Request) io.Reader {
+	return http.MaxBytesReader(w, r.Body, maxBodyBytes)
+}
+
+// writeBodyError answers a request whose body could not be decoded, with
+// 413 and the code body_too_large if it was over maxBodyBytes
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large",
+			fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+// writeError writes an ErrorResponse with the given status
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message})
+}
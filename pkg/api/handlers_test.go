@@ -2,14 +2,22 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/mxnyawi/gymSharkTask/internal/db"
 	"github.com/mxnyawi/gymSharkTask/internal/db/mocks"
+	"github.com/mxnyawi/gymSharkTask/internal/model"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -208,6 +216,11 @@ func TestLoginHandler(t *testing.T) {
 }
 
 func TestPostOrderHandler(t *testing.T) {
+	tooManySizes := make([]string, model.MaxSizes+1)
+	for i := range tooManySizes {
+		tooManySizes[i] = strconv.Itoa(i + 1)
+	}
+
 	tests := []struct {
 		name           string
 		method         string
@@ -215,6 +228,7 @@ func TestPostOrderHandler(t *testing.T) {
 		body           string
 		mockDBManager  func() *mocks.MockDBManager
 		expectedStatus int
+		expectedCode   string
 	}{
 		{
 			name:           "Method not allowed",
@@ -241,210 +255,306 @@ func TestPostOrderHandler(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 		},
 		{
-			name:        "Could not get database credentials",
-			method:      http.MethodPost,
-			contentType: "application/json",
-			body:        `{"orderAmount": 10, "packageSizes": [5, 5]}`,
-			mockDBManager: func() *mocks.MockDBManager {
-				m := &mocks.MockDBManager{}
-				m.On("GetDBCreds").Return("", "", "", "", errors.New("test error"))
-				return m
-			},
-			expectedStatus: http.StatusInternalServerError,
+			name:           "Empty package sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": []}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "empty_sizes",
 		},
 		{
-			name:        "Order created",
+			name:           "Zero package size",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [0, 5]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_size",
+		},
+		{
+			name:           "Duplicate package sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [5, 5]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "duplicate_size",
+		},
+		{
+			name:           "Too many package sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [` + strings.Join(tooManySizes, ", ") + `]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "too_many_sizes",
+		},
+		{
+			name:           "Body too large",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [5, 10], "sku": "` + strings.Repeat("x", maxBodyBytes) + `"}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedCode:   "body_too_large",
+		},
+		{
+			name:           "Negative order amount",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": -10, "packageSizes": [5, 10]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "non_positive_amount",
+		},
+		{
+			name:           "Order amount too large",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 1000000001, "packageSizes": [5, 10]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "amount_too_large",
+		},
+		{
+			name:        "Catalogue too wide",
 			method:      http.MethodPost,
 			contentType: "application/json",
-			body:        `{"orderAmount": 12, "packageSizes": [5, 10, 15, 20, 25]}`,
+			body:        `{"orderAmount": 20000000, "packageSizes": [4999, 5000]}`,
 			mockDBManager: func() *mocks.MockDBManager {
 				m := &mocks.MockDBManager{}
-				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
-				m.On("GetDocument", "bucket", "scope", "collection", "document").Return(&db.DocumentHistory{}, nil)
-				m.On("WriteDocument", "bucket", "scope", "collection", "document", mock.AnythingOfType("*db.DocumentHistory")).Return(nil)
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "password", nil)
 				return m
 			},
-			expectedStatus: http.StatusCreated,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "catalogue_too_wide",
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest(tt.method, "/order", bytes.NewBufferString(tt.body))
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			req.Header.Set("Content-Type", tt.contentType)
-
-			rr := httptest.NewRecorder()
-
-			dbManager := tt.mockDBManager()
-
-			PostOrderHandler(rr, req, dbManager)
-
-			if status := rr.Code; status != tt.expectedStatus {
-				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
-			}
-		})
-	}
-}
-
-func TestCreateAdminUserHandler(t *testing.T) {
-	tests := []struct {
-		name           string
-		method         string
-		contentType    string
-		body           string
-		mockDBManager  func() *mocks.MockDBManager
-		expectedStatus int
-	}{
 		{
-			name:           "Method not allowed",
-			method:         http.MethodGet,
+			name:           "Cost objective without prices",
+			method:         http.MethodPost,
 			contentType:    "application/json",
-			body:           `{"username": "test", "password": "test"}`,
+			body:           `{"orderAmount": 10, "packageSizes": [5, 10], "objective": "cost"}`,
 			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
-			expectedStatus: http.StatusMethodNotAllowed,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "missing_prices",
 		},
 		{
-			name:           "Content-Type header is not application/json",
+			name:           "Unknown objective",
 			method:         http.MethodPost,
-			contentType:    "text/plain",
-			body:           `{"username": "test", "password": "test"}`,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [5, 10], "objective": "fastest"}`,
 			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
-			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "unknown_objective",
 		},
 		{
-			name:           "Invalid request body",
+			name:           "Unknown strategy",
 			method:         http.MethodPost,
 			contentType:    "application/json",
-			body:           `{"username": "test", "password":`,
+			body:           `{"orderAmount": 10, "packageSizes": [5, 10], "strategy": "fastest"}`,
 			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "unknown_strategy",
 		},
 		{
-			name:           "Username and password are required",
+			name:           "Unknown tie-break policy",
 			method:         http.MethodPost,
 			contentType:    "application/json",
-			body:           `{"username": "", "password": ""}`,
+			body:           `{"orderAmount": 10, "packageSizes": [5, 10], "tieBreak": "random"}`,
 			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "unknown_tie_break",
 		},
 		{
-			name:        "Could not create admin user",
+			name:           "Strategy cannot honour objective",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [5, 10], "packagePrices": [1, 2], "objective": "cost", "strategy": "greedy"}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "unsupported_option",
+		},
+		{
+			name:        "Could not get database credentials",
 			method:      http.MethodPost,
 			contentType: "application/json",
-			body:        `{"username": "test", "password": "test"}`,
+			body:        `{"orderAmount": 10, "packageSizes": [5, 10]}`,
 			mockDBManager: func() *mocks.MockDBManager {
 				m := &mocks.MockDBManager{}
-				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
-				m.On("CreateAdminUser", "test", "test").Return(errors.New("test error"))
+				m.On("GetDBCreds").Return("", "", "", "", errors.New("test error"))
 				return m
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
 		{
-			name:        "Admin user created",
+			name:        "Order created",
 			method:      http.MethodPost,
 			contentType: "application/json",
-			body:        `{"username": "test", "password": "test"}`,
+			body:        `{"orderAmount": 12, "packageSizes": [5, 10, 15, 20, 25]}`,
 			mockDBManager: func() *mocks.MockDBManager {
 				m := &mocks.MockDBManager{}
 				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
-				m.On("CreateAdminUser", "test", "test").Return(nil)
+				m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).Return(nil)
 				return m
 			},
 			expectedStatus: http.StatusCreated,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest(tt.method, "/createAdminUser", bytes.NewBufferString(tt.body))
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			req.Header.Set("Content-Type", tt.contentType)
-
-			rr := httptest.NewRecorder()
-
-			dbManager := tt.mockDBManager()
-
-			CreateAdminUserHandler(rr, req, dbManager)
-
-			if status := rr.Code; status != tt.expectedStatus {
-				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
-			}
-		})
-	}
-}
-
-func TestSetDocumentHandler(t *testing.T) {
-	tests := []struct {
-		name           string
-		method         string
-		contentType    string
-		body           string
-		mockDBManager  func() *mocks.MockDBManager
-		expectedStatus int
-	}{
 		{
-			name:           "Method not allowed",
-			method:         http.MethodGet,
-			contentType:    "application/json",
-			body:           `{"orderAmount": 10, "packageSizes": [5, 5]}`,
-			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
-			expectedStatus: http.StatusMethodNotAllowed,
+			name:        "Order from stock",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000], "useStock": true}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetStock", "bucket", "scope", "collection", db.StockDocumentID).Return(&db.Stock{Levels: map[int]int{5000: 1}}, nil)
+				m.On("DecrementStock", "bucket", "scope", "collection", db.StockDocumentID, map[int]int{250: 1, 1000: 1, 2000: 3, 5000: 1}).Return(nil)
+				m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).Return(nil)
+				return m
+			},
+			expectedStatus: http.StatusCreated,
 		},
 		{
-			name:           "Content-Type header is not application/json",
-			method:         http.MethodPost,
-			contentType:    "text/plain",
-			body:           `{"orderAmount": 10, "packageSizes": [5, 5]}`,
-			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
-			expectedStatus: http.StatusUnsupportedMediaType,
+			name:        "Not enough stock",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"orderAmount": 12001, "packageSizes": [250, 5000], "useStock": true}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetStock", "bucket", "scope", "collection", db.StockDocumentID).Return(&db.Stock{Levels: map[int]int{250: 4, 5000: 2}}, nil)
+				return m
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "insufficient_stock",
 		},
 		{
-			name:           "Invalid request body",
-			method:         http.MethodPost,
-			contentType:    "application/json",
-			body:           `{"orderAmount": 10, "packageSizes": [5,`,
-			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
-			expectedStatus: http.StatusBadRequest,
+			name:        "Stock taken by another order",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"orderAmount": 500, "packageSizes": [250, 500], "useStock": true}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetStock", "bucket", "scope", "collection", db.StockDocumentID).Return(&db.Stock{Levels: map[int]int{500: 1}}, nil)
+				m.On("DecrementStock", "bucket", "scope", "collection", db.StockDocumentID, map[int]int{500: 1}).Return(db.ErrInsufficientStock)
+				return m
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "insufficient_stock",
 		},
 		{
-			name:        "Could not get database credentials",
+			name:        "No stock levels configured",
 			method:      http.MethodPost,
 			contentType: "application/json",
-			body:        `{"orderAmount": 10, "packageSizes": [5, 5]}`,
+			body:        `{"orderAmount": 500, "packageSizes": [250, 500], "useStock": true}`,
 			mockDBManager: func() *mocks.MockDBManager {
 				m := &mocks.MockDBManager{}
-				m.On("GetDBCreds").Return("", "", "", "", errors.New("test error"))
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetStock", "bucket", "scope", "collection", db.StockDocumentID).Return((*db.Stock)(nil), fmt.Errorf("failed to get stock: %w", db.ErrNotFound))
+				return m
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "stock_not_configured",
+		},
+		{
+			name:        "Stock restored when the order is not written",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"orderAmount": 500, "packageSizes": [250, 500], "useStock": true}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetStock", "bucket", "scope", "collection", db.StockDocumentID).Return(&db.Stock{Levels: map[int]int{500: 1}}, nil)
+				m.On("DecrementStock", "bucket", "scope", "collection", db.StockDocumentID, map[int]int{500: 1}).Return(nil).Once()
+				m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).Return(errors.New("test error"))
+				m.On("DecrementStock", "bucket", "scope", "collection", db.StockDocumentID, map[int]int{500: -1}).Return(nil).Once()
 				return m
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
 		{
-			name:        "Document created",
+			name:        "Multi-line order created",
 			method:      http.MethodPost,
 			contentType: "application/json",
-			body:        `{"orderAmount": 12, "packageSizes": [5, 10, 15, 20, 25]}`,
+			body: `{"lines": [
+				{"sku": "shaker", "orderAmount": 501, "packageSizes": [250, 500, 1000]},
+				{"sku": "gloves", "orderAmount": 12, "catalogue": "gloves"}
+			]}`,
 			mockDBManager: func() *mocks.MockDBManager {
 				m := &mocks.MockDBManager{}
 				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
-				m.On("GetDocument", "bucket", "scope", "collection", "document").Return(nil, errors.New("test error"))
-				m.On("WriteDocument", "bucket", "scope", "collection", "document", db.Document{}).Return(nil)
+				m.On("GetCatalogue", "bucket", "scope", "collection", db.CatalogueID("gloves")).Return(&model.Packages{Sizes: []int{5, 10}}, nil)
+				m.On("InsertOrders", "bucket", "scope", "collection", mock.MatchedBy(func(orders []db.OrderDocument) bool {
+					return len(orders) == 1 && orders[0].MultiLine != nil && len(orders[0].MultiLine.Lines) == 2
+				})).Return(nil)
 				return m
 			},
 			expectedStatus: http.StatusCreated,
 		},
+		{
+			name:        "Multi-line order with unknown catalogue",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"lines": [{"sku": "gloves", "orderAmount": 12, "catalogue": "missing"}]}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetCatalogue", "bucket", "scope", "collection", db.CatalogueID("missing")).Return((*model.Packages)(nil), db.ErrNotFound)
+				return m
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "unknown_catalogue",
+		},
+		{
+			name:           "Multi-line order mixing a catalogue with sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"lines": [{"sku": "gloves", "orderAmount": 12, "catalogue": "gloves", "packageSizes": [5, 10]}]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "conflicting_packages",
+		},
+		{
+			name:           "Multi-line order mixing a catalogue with prices",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"lines": [{"sku": "gloves", "orderAmount": 12, "catalogue": "gloves", "packagePrices": [3, 5]}]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "conflicting_packages",
+		},
+		{
+			name:        "Multi-line order with duplicate SKU",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body: `{"lines": [
+				{"sku": "gloves", "orderAmount": 12, "packageSizes": [5, 10]},
+				{"sku": "gloves", "orderAmount": 3, "packageSizes": [5, 10]}
+			]}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				return m
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "duplicate_sku",
+		},
+		{
+			name:           "Multi-line order from stock",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"useStock": true, "lines": [{"sku": "gloves", "orderAmount": 12, "packageSizes": [5, 10]}]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "stock_not_supported",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest(tt.method, "/setDocument", bytes.NewBufferString(tt.body))
+			req, err := http.NewRequest(tt.method, "/order", bytes.NewBufferString(tt.body))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -455,16 +565,439 @@ func TestSetDocumentHandler(t *testing.T) {
 
 			dbManager := tt.mockDBManager()
 
-			SetDocumentHandler(rr, req, dbManager)
+			PostOrderHandler(rr, req, dbManager, NewCatalogueCache(defaultCatalogueCacheBytes))
 
 			if status := rr.Code; status != tt.expectedStatus {
 				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
 			}
+
+			dbManager.AssertExpectations(t)
+
+			if tt.expectedCode != "" {
+				var resp ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", resp.Code, tt.expectedCode)
+				}
+			}
 		})
 	}
 }
 
-func TestGetDocumentHandler(t *testing.T) {
+func TestPostOrderHandlerCost(t *testing.T) {
+	var orders []db.OrderDocument
+	m := &mocks.MockDBManager{}
+	m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+	m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+		Run(func(args mock.Arguments) { orders = args.Get(3).([]db.OrderDocument) }).
+		Return(nil)
+
+	body := `{"orderAmount": 501, "packageSizes": [250, 500, 1000], "packagePrices": [300, 550, 800], "objective": "cost"}`
+	req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var order model.Order
+	if err := json.NewDecoder(rr.Body).Decode(&order); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{1000}; !reflect.DeepEqual(order.Result, want) || order.Cost != 800 {
+		t.Errorf("handler returned %v costing %d, want %v costing 800", order.Result, order.Cost, want)
+	}
+
+	if want := []model.PackCount{{Size: 1000, Count: 1}}; !reflect.DeepEqual(order.Packing.Packs, want) || order.Packing.Overshoot != 499 {
+		t.Errorf("handler returned packing %+v, want %v with overshoot 499", order.Packing, want)
+	}
+
+	if stored := orders[0].Order.Packing; !reflect.DeepEqual(stored, order.Packing) {
+		t.Errorf("stored packing = %+v, want %+v", stored, order.Packing)
+	}
+
+	if stored := orders[0].Order.Cost; stored != 800 {
+		t.Errorf("stored order cost = %d, want 800", stored)
+	}
+}
+
+func TestPostOrderHandlerExplain(t *testing.T) {
+	m := &mocks.MockDBManager{}
+	m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+	m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).Return(nil)
+
+	body := `{"orderAmount": 501, "packageSizes": [250, 500, 1000, 2000, 5000]}`
+	req := httptest.NewRequest(http.MethodPost, "/order?explain=true", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var response OrderResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{250, 500}; !reflect.DeepEqual(response.Result, want) {
+		t.Errorf("handler returned %v, want %v", response.Result, want)
+	}
+
+	explanation := response.Explanation
+	if explanation == nil {
+		t.Fatal("handler returned no explanation")
+	}
+
+	if explanation.DecidedBy != "items" || len(explanation.RunnersUp) == 0 {
+		t.Fatalf("handler explained %+v, want a runner-up beaten on items", explanation)
+	}
+
+	if want := []model.PackCount{{Size: 1000, Count: 1}}; !reflect.DeepEqual(explanation.RunnersUp[0].Packs, want) {
+		t.Errorf("handler returned runner-up %v, want %v", explanation.RunnersUp[0].Packs, want)
+	}
+
+	t.Run("Multi-line orders", func(t *testing.T) {
+		body := `{"lines": [{"sku": "shaker", "orderAmount": 501, "packageSizes": [250, 500]}]}`
+		req := httptest.NewRequest(http.MethodPost, "/order?explain=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		PostOrderHandler(rr, req, &mocks.MockDBManager{}, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestPostOrderHandlerShipments(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		maxPackages    string
+		expectedStatus int
+		expectedCode   string
+		want           []int
+	}{
+		{
+			name:           "One parcel without limits",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000]}`,
+			expectedStatus: http.StatusCreated,
+			want:           []int{4},
+		},
+		{
+			name:           "Package count limit",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000], "parcel": {"maxPackages": 3}}`,
+			expectedStatus: http.StatusCreated,
+			want:           []int{3, 1},
+		},
+		{
+			name:           "Weight limit",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000], "packageWeights": [300, 550, 1000, 1900, 4500], "parcel": {"maxWeight": 5000}}`,
+			expectedStatus: http.StatusCreated,
+			want:           []int{2, 1, 1},
+		},
+		{
+			name:           "Server default limit",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000]}`,
+			maxPackages:    "2",
+			expectedStatus: http.StatusCreated,
+			want:           []int{2, 2},
+		},
+		{
+			name:           "Missing weights",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000], "parcel": {"maxWeight": 5000}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "missing_weights",
+		},
+		{
+			name:           "Package too heavy",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 5000], "packageWeights": [300, 4500], "parcel": {"maxWeight": 1000}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "package_too_heavy",
+		},
+		{
+			name:           "Multi-line orders",
+			body:           `{"lines": [{"sku": "shaker", "orderAmount": 501, "packageSizes": [250, 500]}], "parcel": {"maxPackages": 1}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "shipping_not_supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PARCEL_MAX_PACKAGES", tt.maxPackages)
+
+			var orders []db.OrderDocument
+			m := &mocks.MockDBManager{}
+			m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+			m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+				Run(func(args mock.Arguments) { orders = args.Get(3).([]db.OrderDocument) }).
+				Return(nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+				return
+			}
+
+			var response OrderResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]int, len(response.Shipments))
+			for i, shipment := range response.Shipments {
+				got[i] = shipment.Packages
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handler returned parcels of %v packages, want %v", got, tt.want)
+			}
+
+			if stored := orders[0].Shipments; !reflect.DeepEqual(stored, response.Shipments) {
+				t.Errorf("stored shipments = %+v, want %+v", stored, response.Shipments)
+			}
+		})
+	}
+}
+
+func TestPostOrderHandlerHierarchy(t *testing.T) {
+	var orders []db.OrderDocument
+	m := &mocks.MockDBManager{}
+	m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+	m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+		Run(func(args mock.Arguments) { orders = args.Get(3).([]db.OrderDocument) }).
+		Return(nil)
+
+	body := `{"orderAmount": 265001, "packageSizes": [250, 500, 1000, 2000, 5000],
+		"hierarchy": {"packSize": 5000, "levels": [{"name": "carton", "holds": 4}, {"name": "pallet", "holds": 5}]}}`
+	req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var order model.Order
+	if err := json.NewDecoder(rr.Body).Decode(&order); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, len(order.Tree))
+	for i, unit := range order.Tree {
+		got[i] = fmt.Sprintf("%d %s of %d", unit.Count, unit.Level, unit.Items)
+	}
+
+	want := []string{"2 pallet of 100000", "3 carton of 20000", "1 pack of 5000", "1 pack of 250"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handler returned tree %v, want %v", got, want)
+	}
+
+	stored := orders[0]
+	if !reflect.DeepEqual(stored.Order.Tree, order.Tree) || stored.Packages.Hierarchy == nil {
+		t.Errorf("stored order %+v with packages %+v, want tree %+v", stored.Order, stored.Packages, order.Tree)
+	}
+
+	t.Run("Invalid hierarchy", func(t *testing.T) {
+		body := `{"orderAmount": 501, "packageSizes": [250, 500], "hierarchy": {"packSize": 300, "levels": [{"name": "carton", "holds": 4}]}}`
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		PostOrderHandler(rr, req, &mocks.MockDBManager{}, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+		var response ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != http.StatusBadRequest || response.Code != "invalid_hierarchy" {
+			t.Errorf("handler returned %v %q, want %v %q", rr.Code, response.Code, http.StatusBadRequest, "invalid_hierarchy")
+		}
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultStrategy string
+		wantErr         error
+	}{
+		{name: "No default strategy"},
+		{name: "Known default strategy", defaultStrategy: "greedy"},
+		{name: "Unknown default strategy", defaultStrategy: "fastest", wantErr: model.ErrUnknownStrategy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_STRATEGY", tt.defaultStrategy)
+
+			if err := ValidateConfig(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateConfig() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPostOrderHandlerStrategy(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		defaultStrategy string
+		want            []int
+		wantStrategy    string
+	}{
+		{name: "Exact by default", body: `{"orderAmount": 8, "packageSizes": [4, 7]}`, want: []int{4, 4}, wantStrategy: "exact"},
+		{name: "Greedy requested", body: `{"orderAmount": 8, "packageSizes": [4, 7], "strategy": "greedy"}`, want: []int{4, 7}, wantStrategy: "greedy"},
+		{name: "Server default", body: `{"orderAmount": 8, "packageSizes": [4, 7]}`, defaultStrategy: "greedy", want: []int{4, 7}, wantStrategy: "greedy"},
+		{name: "Request overrides server default", body: `{"orderAmount": 8, "packageSizes": [4, 7], "strategy": "exact"}`, defaultStrategy: "greedy", want: []int{4, 4}, wantStrategy: "exact"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_STRATEGY", tt.defaultStrategy)
+
+			var orders []db.OrderDocument
+			m := &mocks.MockDBManager{}
+			m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+			m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+				Run(func(args mock.Arguments) { orders = args.Get(3).([]db.OrderDocument) }).
+				Return(nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+			}
+
+			var order model.Order
+			if err := json.NewDecoder(rr.Body).Decode(&order); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(order.Result, tt.want) {
+				t.Errorf("handler returned %v, want %v", order.Result, tt.want)
+			}
+
+			if stored := orders[0].Strategy; stored != tt.wantStrategy {
+				t.Errorf("stored strategy = %q, want %q", stored, tt.wantStrategy)
+			}
+		})
+	}
+}
+
+func TestPostOrderHandlerConcurrent(t *testing.T) {
+	tests := []struct {
+		body string
+		want []int
+	}{
+		{body: `{"orderAmount": 501, "packageSizes": [250, 500, 1000, 2000, 5000]}`, want: []int{250, 500}},
+		{body: `{"orderAmount": 12, "packageSizes": [25, 20, 15, 10, 5]}`, want: []int{15}},
+		{body: `{"orderAmount": 8, "packageSizes": [7, 4]}`, want: []int{4, 4}},
+	}
+
+	// Every stored order is copied out of the call, so no fixture state is
+	// shared between the handlers and only their own locking is exercised
+	var mu sync.Mutex
+	stored := make(map[string][]int)
+
+	m := &mocks.MockDBManager{}
+	m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+	m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+		Run(func(args mock.Arguments) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, order := range args.Get(3).([]db.OrderDocument) {
+				stored[order.ID] = append([]int(nil), order.Order.Result...)
+			}
+		}).
+		Return(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, tt := range tests {
+			wg.Add(1)
+			go func(body string, want []int) {
+				defer wg.Done()
+
+				req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+				req.Header.Set("Content-Type", "application/json")
+				rr := httptest.NewRecorder()
+
+				PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+				if rr.Code != http.StatusCreated {
+					t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+					return
+				}
+
+				var order model.Order
+				if err := json.NewDecoder(rr.Body).Decode(&order); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if !reflect.DeepEqual(order.Result, want) {
+					t.Errorf("handler returned wrong packages for %s: got %v want %v", body, order.Result, want)
+				}
+			}(tt.body, tt.want)
+		}
+	}
+	wg.Wait()
+
+	// Orders never read back a shared history to append to
+	m.AssertNotCalled(t, "GetDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	results := make(map[string]int)
+	for _, result := range stored {
+		results[fmt.Sprint(result)]++
+	}
+
+	if len(stored) != 50*len(tests) {
+		t.Errorf("stored %d orders, want %d", len(stored), 50*len(tests))
+	}
+	for _, tt := range tests {
+		if results[fmt.Sprint(tt.want)] != 50 {
+			t.Errorf("stored %d orders packed as %v, want 50", results[fmt.Sprint(tt.want)], tt.want)
+		}
+	}
+}
+
+func TestSetCatalogueHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		method         string
@@ -475,50 +1008,54 @@ func TestGetDocumentHandler(t *testing.T) {
 	}{
 		{
 			name:           "Method not allowed",
-			method:         http.MethodPost,
+			method:         http.MethodGet,
 			contentType:    "application/json",
-			body:           `{"documentID": "test"}`,
+			body:           `{"name": "gloves", "packages": {"sizes": [5, 10]}}`,
 			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
 			expectedStatus: http.StatusMethodNotAllowed,
 		},
 		{
 			name:           "Content-Type header is not application/json",
-			method:         http.MethodGet,
+			method:         http.MethodPost,
 			contentType:    "text/plain",
-			body:           `{"documentID": "test"}`,
+			body:           `{"name": "gloves", "packages": {"sizes": [5, 10]}}`,
 			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
 			expectedStatus: http.StatusUnsupportedMediaType,
 		},
 		{
-			name:        "Could not get database credentials",
-			method:      http.MethodGet,
-			contentType: "application/json",
-			body:        `{"documentID": "test"}`,
-			mockDBManager: func() *mocks.MockDBManager {
-				m := &mocks.MockDBManager{}
-				m.On("GetDBCreds").Return("", "", "", "", errors.New("test error"))
-				return m
-			},
-			expectedStatus: http.StatusInternalServerError,
+			name:           "Catalogue name is required",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"packages": {"sizes": [5, 10]}}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
 		},
 		{
-			name:        "Document retrieved",
-			method:      http.MethodGet,
+			name:           "Invalid package sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"name": "gloves", "packages": {"sizes": [5, 5]}}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "Catalogue created",
+			method:      http.MethodPost,
 			contentType: "application/json",
-			body:        `{"documentID": "test"}`,
+			body:        `{"name": "gloves", "packages": {"sizes": [5, 10]}}`,
 			mockDBManager: func() *mocks.MockDBManager {
 				m := &mocks.MockDBManager{}
 				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
-				m.On("GetDocument", "bucket", "scope", "collection", "document").Return(&db.DocumentHistory{}, nil)
+				m.On("WriteDocument", "bucket", "scope", "collection", db.CatalogueID("gloves"), model.Packages{Sizes: []int{5, 10}}).Return(nil)
 				return m
 			},
-			expectedStatus: http.StatusOK,
+			expectedStatus: http.StatusCreated,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest(tt.method, "/getDocument", bytes.NewBufferString(tt.body))
+			req, err := http.NewRequest(tt.method, "/catalogue", bytes.NewBufferString(tt.body))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -529,7 +1066,7 @@ func TestGetDocumentHandler(t *testing.T) {
 
 			dbManager := tt.mockDBManager()
 
-			GetDocumentHandler(rr, req, dbManager)
+			SetCatalogueHandler(rr, req, dbManager)
 
 			if status := rr.Code; status != tt.expectedStatus {
 				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
@@ -537,3 +1074,1373 @@ func TestGetDocumentHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateAdminUserHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		contentType    string
+		body           string
+		mockDBManager  func() *mocks.MockDBManager
+		expectedStatus int
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodGet,
+			contentType:    "application/json",
+			body:           `{"username": "test", "password": "test"}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Content-Type header is not application/json",
+			method:         http.MethodPost,
+			contentType:    "text/plain",
+			body:           `{"username": "test", "password": "test"}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Invalid request body",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"username": "test", "password":`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Username and password are required",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"username": "", "password": ""}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "Could not create admin user",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"username": "test", "password": "test"}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("CreateAdminUser", "test", "test").Return(errors.New("test error"))
+				return m
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:        "Admin user created",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"username": "test", "password": "test"}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("CreateAdminUser", "test", "test").Return(nil)
+				return m
+			},
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "/createAdminUser", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+
+			dbManager := tt.mockDBManager()
+
+			CreateAdminUserHandler(rr, req, dbManager)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestSetDocumentHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		contentType    string
+		body           string
+		mockDBManager  func() *mocks.MockDBManager
+		expectedStatus int
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodGet,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [5, 5]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Content-Type header is not application/json",
+			method:         http.MethodPost,
+			contentType:    "text/plain",
+			body:           `{"orderAmount": 10, "packageSizes": [5, 5]}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Invalid request body",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 10, "packageSizes": [5,`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "Could not get database credentials",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"orderAmount": 10, "packageSizes": [5, 5]}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("", "", "", "", errors.New("test error"))
+				return m
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:        "Document created",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"orderAmount": 12, "packageSizes": [5, 10, 15, 20, 25]}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetDocument", "bucket", "scope", "collection", "document").Return((*db.DocumentHistory)(nil), db.Cas(0), errors.New("test error"))
+				m.On("WriteDocument", "bucket", "scope", "collection", "document", db.Document{}).Return(nil)
+				return m
+			},
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "/setDocument", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+
+			dbManager := tt.mockDBManager()
+
+			SetDocumentHandler(rr, req, dbManager)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestGetDocumentHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		contentType    string
+		body           string
+		mockDBManager  func() *mocks.MockDBManager
+		expectedStatus int
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"documentID": "test"}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Content-Type header is not application/json",
+			method:         http.MethodGet,
+			contentType:    "text/plain",
+			body:           `{"documentID": "test"}`,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Could not get database credentials",
+			method:      http.MethodGet,
+			contentType: "application/json",
+			body:        `{"documentID": "test"}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("", "", "", "", errors.New("test error"))
+				return m
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:        "Document retrieved",
+			method:      http.MethodGet,
+			contentType: "application/json",
+			body:        `{"documentID": "test"}`,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("GetDocument", "bucket", "scope", "collection", "document").Return(&db.DocumentHistory{}, db.Cas(1), nil)
+				return m
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "/getDocument", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+
+			dbManager := tt.mockDBManager()
+
+			GetDocumentHandler(rr, req, dbManager)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		wantOvershoot  []int
+		wantWrites     int
+		insertErr      error
+		wantBatch      string
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodGet,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [1, 501], "packageSizes": [250, 500, 1000]}`,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Content-Type header is not application/json",
+			method:         http.MethodPost,
+			contentType:    "text/plain",
+			body:           `{"orderAmounts": [1, 501], "packageSizes": [250, 500, 1000]}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "No amounts",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [], "packageSizes": [250, 500, 1000]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_batch",
+		},
+		{
+			name:           "Invalid amount",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [501, -1], "packageSizes": [250, 500, 1000]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "non_positive_amount",
+		},
+		{
+			name:           "Not stored",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [1, 501, 12001], "packageSizes": [250, 500, 1000, 2000, 5000]}`,
+			expectedStatus: http.StatusOK,
+			wantOvershoot:  []int{249, 249, 249},
+		},
+		{
+			name:           "Stored in one write",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [250, 251], "packageSizes": [250, 500, 1000], "persist": true}`,
+			expectedStatus: http.StatusOK,
+			wantOvershoot:  []int{0, 249},
+			wantWrites:     1,
+		},
+		{
+			name:           "Stored with a batch ID",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [250, 251], "packageSizes": [250, 500, 1000], "persist": true, "batchId": "import-7"}`,
+			expectedStatus: http.StatusOK,
+			wantOvershoot:  []int{0, 249},
+			wantWrites:     1,
+			wantBatch:      "import-7",
+		},
+		{
+			name:           "Batch already stored",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [250, 251], "packageSizes": [250, 500, 1000], "persist": true, "batchId": "import-7"}`,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "batch_exists",
+			wantWrites:     1,
+			insertErr:      db.ErrDocumentExists,
+		},
+		{
+			name:           "Batch not stored",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [250, 251], "packageSizes": [250, 500, 1000], "persist": true}`,
+			expectedStatus: http.StatusInternalServerError,
+			wantWrites:     1,
+			insertErr:      errors.New("transaction failed"),
+		},
+		{
+			name:           "Batch ID too long",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmounts": [250], "packageSizes": [250, 500, 1000], "persist": true, "batchId": "` + strings.Repeat("b", 201) + `"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_batch_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var orders []db.OrderDocument
+			m := &mocks.MockDBManager{}
+			m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+			m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+				Run(func(args mock.Arguments) { orders = args.Get(3).([]db.OrderDocument) }).
+				Return(tt.insertErr)
+
+			req := httptest.NewRequest(tt.method, "/order/batch", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+
+			BatchHandler(rr, req, m)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+			}
+
+			m.AssertNumberOfCalls(t, "InsertOrders", tt.wantWrites)
+
+			if tt.wantOvershoot == nil {
+				return
+			}
+
+			var response BatchResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]int, len(response.Orders))
+			for i, order := range response.Orders {
+				got[i] = order.Packing.Overshoot
+			}
+
+			if !reflect.DeepEqual(got, tt.wantOvershoot) {
+				t.Errorf("handler returned overshoots %v, want %v", got, tt.wantOvershoot)
+			}
+
+			if tt.wantWrites == 0 {
+				return
+			}
+
+			if len(orders) != len(response.Orders) {
+				t.Errorf("stored %d orders, want %d", len(orders), len(response.Orders))
+			}
+
+			for i, order := range orders {
+				if order.Batch != tt.wantBatch {
+					t.Errorf("order %d stored with batch %q, want %q", i, order.Batch, tt.wantBatch)
+				}
+				if i >= len(response.OrderIDs) || response.OrderIDs[i] != order.ID {
+					t.Errorf("handler returned order IDs %v, want %s at %d", response.OrderIDs, order.ID, i)
+				}
+			}
+		})
+	}
+}
+
+func TestAlternativesHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		want           [][]int
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodGet,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 501, "packageSizes": [250, 500, 1000]}`,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Content-Type header is not application/json",
+			method:         http.MethodPost,
+			contentType:    "text/plain",
+			body:           `{"orderAmount": 501, "packageSizes": [250, 500, 1000]}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Invalid request body",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": "501"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Too many alternatives",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 501, "packageSizes": [250, 500, 1000], "count": 1000}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_alternatives",
+		},
+		{
+			name:           "Invalid package sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 501, "packageSizes": [250, 250]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "duplicate_size",
+		},
+		{
+			name:           "Alternatives found",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"orderAmount": 501, "packageSizes": [250, 500, 1000], "count": 3}`,
+			expectedStatus: http.StatusOK,
+			want:           [][]int{{250, 500}, {250, 250, 250}, {1000}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "/order/alternatives", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+			AlternativesHandler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+			}
+
+			if tt.want != nil {
+				var response AlternativesResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				got := make([][]int, len(response.Alternatives))
+				for i, order := range response.Alternatives {
+					got[i] = order.Result
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("handler returned %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		expectedStatus int
+		expectedCode   string
+		wantTo         int
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodPost,
+			query:          "?sizes=250,500",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid size",
+			method:         http.MethodGet,
+			query:          "?sizes=250,five",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_query",
+		},
+		{
+			name:           "No sizes",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "empty_sizes",
+		},
+		{
+			name:           "Invalid range",
+			method:         http.MethodGet,
+			query:          "?sizes=250,500&from=100&to=10",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_range",
+		},
+		{
+			name:           "Default range",
+			method:         http.MethodGet,
+			query:          "?sizes=250,500,1000,2000,5000",
+			expectedStatus: http.StatusOK,
+			wantTo:         50000,
+		},
+		{
+			name:           "Given range",
+			method:         http.MethodGet,
+			query:          "?sizes=6,9,20&from=1&to=100",
+			expectedStatus: http.StatusOK,
+			wantTo:         100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/packages/analyze"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			AnalyzeHandler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+			}
+
+			if tt.wantTo != 0 {
+				var analysis model.Analysis
+				if err := json.NewDecoder(rr.Body).Decode(&analysis); err != nil {
+					t.Fatal(err)
+				}
+
+				if analysis.From != 1 || analysis.To != tt.wantTo {
+					t.Errorf("handler analysed %d to %d, want 1 to %d", analysis.From, analysis.To, tt.wantTo)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		adminToken     string
+		token          string
+		expectedStatus int
+	}{
+		{name: "Valid token", adminToken: "secret", token: "secret", expectedStatus: http.StatusOK},
+		{name: "Wrong token", adminToken: "secret", token: "guess", expectedStatus: http.StatusForbidden},
+		{name: "Missing token", adminToken: "secret", expectedStatus: http.StatusForbidden},
+		{name: "No admin token configured", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", tt.adminToken)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/recommend", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Admin-Token", tt.token)
+			}
+			rr := httptest.NewRecorder()
+
+			AdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("middleware returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestRecommendHandler(t *testing.T) {
+	var orders []db.OrderDocument
+	for _, amount := range []int{501, 501, 12001, 250} {
+		orders = append(orders, db.NewOrderDocument(db.Document{
+			Packages: model.Packages{Sizes: []int{250, 500, 1000}},
+			Order:    model.Order{Amount: amount},
+		}))
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		contentType    string
+		body           string
+		orders         []db.OrderDocument
+		ordersErr      error
+		expectedStatus int
+		expectedCode   string
+		wantSizes      []int
+		wantCurrent    []int
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodGet,
+			contentType:    "application/json",
+			body:           `{}`,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Content-Type header is not application/json",
+			method:         http.MethodPost,
+			contentType:    "text/plain",
+			body:           `{}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Could not list orders",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{}`,
+			ordersErr:      errors.New("test error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "No history",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"count": 2}`,
+			orders:         []db.OrderDocument{},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "no_history",
+		},
+		{
+			name:           "Too many sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           fmt.Sprintf(`{"count": %d}`, model.MaxRecommendSizes+1),
+			orders:         orders,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_recommendation",
+		},
+		{
+			name:           "As many sizes as the latest order",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{}`,
+			orders:         orders,
+			expectedStatus: http.StatusOK,
+			wantSizes:      []int{250, 501, 12001},
+			wantCurrent:    []int{250, 500, 1000},
+		},
+		{
+			name:           "Given current sizes",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"count": 1, "include": [500], "currentSizes": [250]}`,
+			orders:         orders,
+			expectedStatus: http.StatusOK,
+			wantSizes:      []int{500},
+			wantCurrent:    []int{250},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/recommend", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+
+			m := &mocks.MockDBManager{}
+			m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+			m.On("ListOrders", "bucket", "scope", "collection", "", mock.Anything).Return(tt.orders, tt.ordersErr)
+
+			RecommendHandler(rr, req, m)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+			}
+
+			if tt.wantSizes != nil {
+				var recommendation model.Recommendation
+				if err := json.NewDecoder(rr.Body).Decode(&recommendation); err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(recommendation.Recommended.Sizes, tt.wantSizes) {
+					t.Errorf("handler recommended %v, want %v", recommendation.Recommended.Sizes, tt.wantSizes)
+				}
+
+				if recommendation.Current == nil || !reflect.DeepEqual(recommendation.Current.Sizes, tt.wantCurrent) {
+					t.Errorf("handler compared against %+v, want %v", recommendation.Current, tt.wantCurrent)
+				}
+			}
+		})
+	}
+}
+
+func TestCatalogueCache(t *testing.T) {
+	cache := NewCatalogueCache(defaultCatalogueCacheBytes)
+
+	sets := []model.Packages{
+		{Sizes: []int{250, 500, 1000}},
+		{Sizes: []int{1000, 250, 500}},
+		{Sizes: []int{23, 31, 53}},
+		{Sizes: []int{250, 500, 1000}, Prices: []int{3, 5, 9}},
+		{Sizes: []int{500, 250, 1000}},
+	}
+	wantStats := []CacheStats{
+		{Hits: 0, Misses: 1, Size: 1},
+		{Hits: 1, Misses: 1, Size: 1},
+		{Hits: 1, Misses: 2, Size: 2},
+		{Hits: 1, Misses: 3, Size: 3},
+		{Hits: 2, Misses: 3, Size: 3},
+	}
+
+	for i, packages := range sets {
+		order, err := cache.Solve(context.Background(), packages, 12001, model.SolveOptions{})
+		if err != nil {
+			t.Fatalf("Solve(%+v) error = %v", packages, err)
+		}
+
+		want, err := packages.Solve(12001, model.SolveOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(order, want) {
+			t.Errorf("Solve(%+v) = %+v, want %+v", packages, order, want)
+		}
+
+		stats := cache.Stats()
+		stats.Bytes, stats.Capacity = 0, 0
+		if stats != wantStats[i] {
+			t.Errorf("after Solve(%+v) stats = %+v, want %+v", packages, stats, wantStats[i])
+		}
+	}
+}
+
+func TestCatalogueCacheCapacity(t *testing.T) {
+	first := model.Packages{Sizes: []int{250, 500, 1000}}
+	second := model.Packages{Sizes: []int{23, 31, 53}}
+
+	measure := NewCatalogueCache(defaultCatalogueCacheBytes)
+	if _, err := measure.Solve(context.Background(), first, 12001, model.SolveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key alone is counted until a query builds the table
+	key, err := catalogueKey(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := measure.Stats().Bytes
+	if size <= len(key) {
+		t.Fatalf("a compiled catalogue is counted as %d bytes, want more than its key", size)
+	}
+
+	cache := NewCatalogueCache(size)
+	for _, packages := range []model.Packages{first, second, first} {
+		if _, err := cache.Solve(context.Background(), packages, 12001, model.SolveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if stats := cache.Stats(); stats.Bytes > stats.Capacity || stats.Size != 1 {
+			t.Errorf("after Solve(%+v) stats = %+v, want one catalogue within the capacity", packages, stats)
+		}
+	}
+
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Misses != 3 {
+		t.Errorf("cache stats = %+v, want every catalogue evicted by the next", stats)
+	}
+}
+
+func TestCatalogueCacheCancelled(t *testing.T) {
+	cache := NewCatalogueCache(defaultCatalogueCacheBytes)
+	packages := model.Packages{Sizes: []int{250, 500, 1000}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := cache.Solve(ctx, packages, 12001, model.SolveOptions{}); !errors.Is(err, model.ErrCancelled) {
+		t.Errorf("Solve() with a cancelled context error = %v, want %v", err, model.ErrCancelled)
+	}
+
+	if _, err := cache.Solve(context.Background(), packages, 12001, model.SolveOptions{}); err != nil {
+		t.Errorf("Solve() after a cancelled query error = %v", err)
+	}
+}
+
+func TestPostOrderHandlerCached(t *testing.T) {
+	catalogues := NewCatalogueCache(defaultCatalogueCacheBytes)
+
+	body := `{"orderAmount": 12001, "packageSizes": [5000, 250, 2000, 500, 1000], "packagePrices": [90, 10, 40, 15, 25], "objective": "cost"}`
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		m := &mocks.MockDBManager{}
+		m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+		m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).Return(nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		PostOrderHandler(rr, req, m, catalogues)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+
+	var req OrderRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	packages := model.Packages{Sizes: req.PackageSizes, Prices: req.PackagePrices}
+	order, err := packages.Solve(req.OrderAmount, req.solveOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shipments, err := packages.Ship(order.Packing, model.ParcelLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want bytes.Buffer
+	json.NewEncoder(&want).Encode(OrderResponse{Order: order, Shipments: shipments})
+
+	for i, got := range bodies {
+		if got != want.String() {
+			t.Errorf("response %d = %s, want %s", i, got, want.String())
+		}
+	}
+
+	if stats := catalogues.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("cache stats = %+v, want 1 hit and 1 miss", stats)
+	}
+}
+
+func TestPostOrderHandlerQuantities(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		wantQuantities *model.Quantities
+	}{
+		{
+			name:           "Kilograms and grams",
+			body:           `{"orderQuantity": {"value": 2.3, "unit": "kg"}, "packageQuantities": [{"value": 500, "unit": "g"}, {"value": 1, "unit": "kg"}]}`,
+			expectedStatus: http.StatusCreated,
+			wantQuantities: &model.Quantities{
+				Amount:    model.Quantity{Value: "2.3", Unit: model.MeasureKilogram},
+				Items:     model.Quantity{Value: "2.5", Unit: model.MeasureKilogram},
+				Overshoot: model.Quantity{Value: "0.2", Unit: model.MeasureKilogram},
+			},
+		},
+		{
+			name:           "Declared scale",
+			body:           `{"orderQuantity": {"value": 2.3, "unit": "kg"}, "packageQuantities": [{"value": 500, "unit": "g"}, {"value": 1, "unit": "kg"}], "scale": {"unit": "g", "precision": 0}}`,
+			expectedStatus: http.StatusCreated,
+			wantQuantities: &model.Quantities{
+				Amount:    model.Quantity{Value: "2300", Unit: model.MeasureGram},
+				Items:     model.Quantity{Value: "2500", Unit: model.MeasureGram},
+				Overshoot: model.Quantity{Value: "200", Unit: model.MeasureGram},
+			},
+		},
+		{
+			name:           "Mixed with sizes",
+			body:           `{"orderQuantity": {"value": 2.3, "unit": "kg"}, "packageSizes": [500, 1000]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_quantity",
+		},
+		{
+			name:           "Weight and volume",
+			body:           `{"orderQuantity": {"value": 2, "unit": "l"}, "packageQuantities": [{"value": 500, "unit": "g"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_quantity",
+		},
+		{
+			name:           "Finer than the scale",
+			body:           `{"orderQuantity": {"value": 2.35, "unit": "kg"}, "packageQuantities": [{"value": 500, "unit": "g"}], "scale": {"unit": "kg", "precision": 1}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_quantity",
+		},
+		{
+			name:           "Unknown unit",
+			body:           `{"orderQuantity": {"value": 2, "unit": "lb"}, "packageQuantities": [{"value": 1, "unit": "lb"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "unknown_measure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var orders []db.OrderDocument
+			m := &mocks.MockDBManager{}
+			m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+			m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).
+				Run(func(args mock.Arguments) { orders = args.Get(3).([]db.OrderDocument) }).
+				Return(nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+				return
+			}
+
+			var response OrderResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(response.Order.Quantities, tt.wantQuantities) {
+				t.Errorf("handler returned quantities %+v, want %+v", response.Order.Quantities, tt.wantQuantities)
+			}
+
+			stored := orders[0]
+			if stored.Packages.Scale == nil || !reflect.DeepEqual(stored.Order.Quantities, tt.wantQuantities) {
+				t.Errorf("stored scale %+v and quantities %+v, want quantities %+v", stored.Packages.Scale, stored.Order.Quantities, tt.wantQuantities)
+			}
+		})
+	}
+}
+
+func TestPostOrderHandlerBudget(t *testing.T) {
+	t.Setenv("SOLVE_BUDGET", "1ns")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		query           string
+		body            string
+		expectedStatus  int
+		expectedCode    string
+		wantApproximate bool
+	}{
+		{
+			name:            "Budget exceeded",
+			ctx:             context.Background(),
+			body:            `{"orderAmount": 100, "packageSizes": [99989, 99991]}`,
+			expectedStatus:  http.StatusCreated,
+			wantApproximate: true,
+		},
+		{
+			name:            "Budget exceeded while explaining",
+			ctx:             context.Background(),
+			query:           "?explain=true",
+			body:            `{"orderAmount": 100, "packageSizes": [99989, 99991]}`,
+			expectedStatus:  http.StatusCreated,
+			wantApproximate: true,
+		},
+		{
+			name:            "Budget exceeded on a line",
+			ctx:             context.Background(),
+			body:            `{"lines": [{"sku": "gloves", "orderAmount": 100, "packageSizes": [99989, 99991]}]}`,
+			expectedStatus:  http.StatusCreated,
+			wantApproximate: true,
+		},
+		{
+			name:           "Budget exceeded with stock",
+			ctx:            context.Background(),
+			body:           `{"orderAmount": 100, "packageSizes": [99989, 99991], "useStock": true}`,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "budget_exceeded",
+		},
+		{
+			name:           "Client gone",
+			ctx:            cancelled,
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000]}`,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "packing_cancelled",
+		},
+		{
+			name:           "Client gone while explaining",
+			ctx:            cancelled,
+			query:          "?explain=true",
+			body:           `{"orderAmount": 12001, "packageSizes": [250, 500, 1000, 2000, 5000]}`,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "packing_cancelled",
+		},
+		{
+			name:           "Client gone on a line",
+			ctx:            cancelled,
+			body:           `{"lines": [{"sku": "gloves", "orderAmount": 12001, "packageSizes": [250, 500, 1000]}]}`,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "packing_cancelled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mocks.MockDBManager{}
+			m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+			m.On("GetStock", "bucket", "scope", "collection", db.StockDocumentID).Return(&db.Stock{Levels: map[int]int{99989: 1}}, nil)
+			m.On("InsertOrders", "bucket", "scope", "collection", mock.AnythingOfType("[]db.OrderDocument")).Return(nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/order"+tt.query, bytes.NewBufferString(tt.body)).WithContext(tt.ctx)
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			PostOrderHandler(rr, req, m, NewCatalogueCache(defaultCatalogueCacheBytes))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+				return
+			}
+
+			var response struct {
+				OrderResponse
+				Lines []model.Line `json:"lines"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			approximate := response.Order.Approximate
+			for _, line := range response.Lines {
+				approximate = line.Order.Approximate
+			}
+			if approximate != tt.wantApproximate {
+				t.Errorf("handler returned approximate %v, want %v", approximate, tt.wantApproximate)
+			}
+		})
+	}
+}
+
+func TestBatchHandlerBudget(t *testing.T) {
+	t.Setenv("SOLVE_BUDGET", "1ns")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "Budget exceeded", ctx: context.Background(), expectedStatus: http.StatusOK},
+		{name: "Client gone", ctx: cancelled, expectedStatus: http.StatusServiceUnavailable, expectedCode: "packing_cancelled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"orderAmounts": [100, 200], "packageSizes": [99989, 99991]}`
+			req := httptest.NewRequest(http.MethodPost, "/order/batch", bytes.NewBufferString(body)).WithContext(tt.ctx)
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			BatchHandler(rr, req, &mocks.MockDBManager{})
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+				return
+			}
+
+			var response BatchResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, order := range response.Orders {
+				if !order.Approximate {
+					t.Errorf("handler returned %+v, want an approximate order", order)
+				}
+			}
+		})
+	}
+}
+
+func TestListOrdersHandler(t *testing.T) {
+	var orders []db.OrderDocument
+	for _, amount := range []int{1, 251, 501} {
+		orders = append(orders, db.NewOrderDocument(db.Document{Order: model.Order{Amount: amount}}))
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		mockDBManager  func() *mocks.MockDBManager
+		expectedStatus int
+		expectedCode   string
+		wantOrders     int
+		wantNext       string
+	}{
+		{
+			name:           "Method not allowed",
+			method:         http.MethodPost,
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid limit",
+			method:         http.MethodGet,
+			query:          "?limit=0",
+			mockDBManager:  func() *mocks.MockDBManager { return &mocks.MockDBManager{} },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_limit",
+		},
+		{
+			name:   "Could not list orders",
+			method: http.MethodGet,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("ListOrders", "bucket", "scope", "collection", "", defaultOrderPageSize).Return([]db.OrderDocument(nil), errors.New("test error"))
+				return m
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:   "Last page",
+			method: http.MethodGet,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("ListOrders", "bucket", "scope", "collection", "", defaultOrderPageSize).Return(orders, nil)
+				return m
+			},
+			expectedStatus: http.StatusOK,
+			wantOrders:     3,
+		},
+		{
+			name:   "Full page",
+			method: http.MethodGet,
+			query:  "?limit=2&after=" + orders[0].ID,
+			mockDBManager: func() *mocks.MockDBManager {
+				m := &mocks.MockDBManager{}
+				m.On("GetDBCreds").Return("bucket", "scope", "collection", "document", nil)
+				m.On("ListOrders", "bucket", "scope", "collection", orders[0].ID, 2).Return(orders[1:], nil)
+				return m
+			},
+			expectedStatus: http.StatusOK,
+			wantOrders:     2,
+			wantNext:       orders[2].ID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/orders"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			ListOrdersHandler(rr, req, tt.mockDBManager())
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedCode != "" {
+				var response ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+
+				if response.Code != tt.expectedCode {
+					t.Errorf("handler returned wrong error code: got %v want %v", response.Code, tt.expectedCode)
+				}
+				return
+			}
+
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response OrderListResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.Orders) != tt.wantOrders || response.Next != tt.wantNext {
+				t.Errorf("handler returned %d orders and next %q, want %d and %q", len(response.Orders), response.Next, tt.wantOrders, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	t.Setenv("BUCKET_NAME", "bucket")
+	t.Setenv("SCOPE_NAME", "scope")
+	t.Setenv("COLLECTION_NAME", "collection")
+	t.Setenv("DOCUMENT_ID", "history")
+
+	dbManager := db.NewMemoryDBManager()
+	if err := dbManager.SetupDB("bucket", "scope", "collection", "history"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbManager.WriteDocument("bucket", "scope", "collection", db.GetStockID(), db.Stock{Levels: map[int]int{250: 2, 500: 0}}); err != nil {
+		t.Fatal(err)
+	}
+
+	catalogues := NewCatalogueCache(defaultCatalogueCacheBytes)
+	handlers := map[string]func(http.ResponseWriter, *http.Request, db.DBManagerInterface){
+		"/registerUser": RegisterHandler,
+		"/loginUser":    LoginHandler,
+		"/order": func(w http.ResponseWriter, r *http.Request, dbManager db.DBManagerInterface) {
+			PostOrderHandler(w, r, dbManager, catalogues)
+		},
+		"/orders": ListOrdersHandler,
+	}
+
+	steps := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Register", http.MethodPost, "/registerUser", `{"username": "test", "password": "test"}`, http.StatusCreated},
+		{"Login", http.MethodPost, "/loginUser", `{"username": "test", "password": "test"}`, http.StatusOK},
+		{"Wrong password", http.MethodPost, "/loginUser", `{"username": "test", "password": "wrong"}`, http.StatusUnauthorized},
+		{"Order", http.MethodPost, "/order", `{"orderAmount": 251, "packageSizes": [250, 500], "useStock": true}`, http.StatusCreated},
+		{"Order without stock", http.MethodPost, "/order", `{"orderAmount": 251, "packageSizes": [250, 500], "useStock": true}`, http.StatusConflict},
+		{"Order without stock check", http.MethodPost, "/order", `{"orderAmount": 1, "packageSizes": [250, 500]}`, http.StatusCreated},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, bytes.NewBufferString(step.body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		handlers[step.path](rr, req, dbManager)
+
+		if rr.Code != step.expectedStatus {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v: %s", step.name, rr.Code, step.expectedStatus, rr.Body)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	rr := httptest.NewRecorder()
+	ListOrdersHandler(rr, req, dbManager)
+
+	var response OrderListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	var amounts []int
+	for _, order := range response.Orders {
+		amounts = append(amounts, order.Order.Amount)
+	}
+	if !reflect.DeepEqual(amounts, []int{251, 1}) {
+		t.Errorf("listed orders of amounts %v, want [251 1]", amounts)
+	}
+
+	stock, err := dbManager.GetStock("bucket", "scope", "collection", db.GetStockID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stock.Levels[250] != 0 {
+		t.Errorf("stock of size 250 is %d, want 0", stock.Levels[250])
+	}
+}
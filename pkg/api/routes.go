@@ -15,6 +15,8 @@ func Routes(dbManager db.DBManagerInterface) {
 	// Middleware to authenticate users
 	r.Use(AuthMiddleware)
 
+	catalogues := newCatalogueCacheFromEnv()
+
 	// User management routes
 	r.HandleFunc("/registerUser", func(w http.ResponseWriter, r *http.Request) {
 		RegisterHandler(w, r, dbManager)
@@ -30,7 +32,41 @@ func Routes(dbManager db.DBManagerInterface) {
 
 	// Order management route
 	r.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
-		PostOrderHandler(w, r, dbManager)
+		PostOrderHandler(w, r, dbManager, catalogues)
+	}).Methods("POST")
+
+	r.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		ListOrdersHandler(w, r, dbManager)
+	}).Methods("GET")
+
+	r.HandleFunc("/order/batch", func(w http.ResponseWriter, r *http.Request) {
+		BatchHandler(w, r, dbManager)
+	}).Methods("POST")
+
+	r.HandleFunc("/order/alternatives", func(w http.ResponseWriter, r *http.Request) {
+		AlternativesHandler(w, r)
+	}).Methods("POST")
+
+	// Package set analysis route
+	r.HandleFunc("/packages/analyze", func(w http.ResponseWriter, r *http.Request) {
+		AnalyzeHandler(w, r)
+	}).Methods("GET")
+
+	// Admin routes, which also need the admin token
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(AdminMiddleware)
+
+	admin.HandleFunc("/recommend", func(w http.ResponseWriter, r *http.Request) {
+		RecommendHandler(w, r, dbManager)
+	}).Methods("POST")
+
+	admin.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
+		CacheStatsHandler(w, r, catalogues)
+	}).Methods("GET")
+
+	// Package catalogue route
+	r.HandleFunc("/catalogue", func(w http.ResponseWriter, r *http.Request) {
+		SetCatalogueHandler(w, r, dbManager)
 	}).Methods("POST")
 
 	// Document management routes
@@ -48,7 +84,7 @@ func Routes(dbManager db.DBManagerInterface) {
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://" + ipAddress + ":3000"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Admin-Token"},
 		AllowCredentials: true,
 	})
 